@@ -4,6 +4,7 @@ package elevio
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -12,54 +13,175 @@ import (
 	"multivator/src/types"
 )
 
-var (
-	_initialized bool = false
-	_mtx         sync.Mutex
-	_conn        net.Conn
+// ElevatorIO is the hardware interface used by the executor.
+//   - TCPClient implements it for the elevator server and the simulator
+type ElevatorIO interface {
+	SetMotorDirection(dir types.MotorDirection)
+	SetButtonLamp(button types.ButtonType, floor int, value bool)
+	SetFloorIndicator(floor int)
+	SetDoorOpenLamp(value bool)
+	SetStopLamp(value bool)
+	GetButton(button types.ButtonType, floor int) bool
+	GetFloor() int
+	GetStop() bool
+	GetObstruction() bool
+	Connected() bool
+}
+
+const (
+	ioTimeout  = 500 * time.Millisecond
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
 )
 
-// Initializes the TCP connection to the elevator server
-func Init(addr string, numFloors int) {
-	if _initialized {
-		fmt.Println("Driver already initialized!")
-		return
-	}
-	_mtx = sync.Mutex{}
-	var err error
-	_conn, err = net.Dial("tcp", addr)
+// TCPClient talks to the elevator server over TCP with the 4-byte protocol.
+//   - On a socket error the connection is dropped, and redialed with exponential backoff on the next call
+//   - While disconnected, writes are discarded and reads return the zero value (no buttons, between floors)
+type TCPClient struct {
+	mtx         sync.Mutex
+	addr        string
+	conn        net.Conn
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+// Dial establishes the TCP connection to the elevator server
+func Dial(addr string) (*TCPClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, ioTimeout)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	_initialized = true
+	return &TCPClient{addr: addr, conn: conn, backoff: minBackoff}, nil
+}
+
+// Connected returns false while the connection to the elevator server is lost
+func (c *TCPClient) Connected() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ensureConn()
+}
+
+func (c *TCPClient) SetMotorDirection(dir types.MotorDirection) {
+	c.write([4]byte{1, byte(dir), 0, 0})
+}
+
+func (c *TCPClient) SetButtonLamp(button types.ButtonType, floor int, value bool) {
+	c.write([4]byte{2, byte(button), byte(floor), toByte(value)})
+}
+
+func (c *TCPClient) SetFloorIndicator(floor int) {
+	c.write([4]byte{3, byte(floor), 0, 0})
 }
 
-func SetMotorDirection(dir types.MotorDirection) {
-	write([4]byte{1, byte(dir), 0, 0})
+func (c *TCPClient) SetDoorOpenLamp(value bool) {
+	c.write([4]byte{4, toByte(value), 0, 0})
+}
+
+func (c *TCPClient) SetStopLamp(value bool) {
+	c.write([4]byte{5, toByte(value), 0, 0})
+}
+
+func (c *TCPClient) GetButton(button types.ButtonType, floor int) bool {
+	a := c.read([4]byte{6, byte(button), byte(floor), 0})
+	return toBool(a[1])
+}
+
+func (c *TCPClient) GetFloor() int {
+	a := c.read([4]byte{7, 0, 0, 0})
+	if a[1] != 0 {
+		return int(a[2])
+	} else {
+		return -1
+	}
 }
 
-func SetButtonLamp(button types.ButtonType, floor int, value bool) {
-	write([4]byte{2, byte(button), byte(floor), toByte(value)})
+func (c *TCPClient) GetStop() bool {
+	a := c.read([4]byte{8, 0, 0, 0})
+	return toBool(a[1])
 }
 
-func SetFloorIndicator(floor int) {
-	write([4]byte{3, byte(floor), 0, 0})
+func (c *TCPClient) GetObstruction() bool {
+	a := c.read([4]byte{9, 0, 0, 0})
+	return toBool(a[1])
 }
 
-func SetDoorOpenLamp(value bool) {
-	write([4]byte{4, toByte(value), 0, 0})
+func (c *TCPClient) read(in [4]byte) [4]byte {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var out [4]byte
+	if !c.ensureConn() {
+		return out
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		c.disconnect(err)
+		return out
+	}
+	if _, err := c.conn.Write(in[:]); err != nil {
+		c.disconnect(err)
+		return out
+	}
+	if _, err := io.ReadFull(c.conn, out[:]); err != nil {
+		c.disconnect(err)
+		return [4]byte{}
+	}
+
+	return out
 }
 
-func SetStopLamp(value bool) {
-	write([4]byte{5, toByte(value), 0, 0})
+func (c *TCPClient) write(in [4]byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.ensureConn() {
+		return
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		c.disconnect(err)
+		return
+	}
+	if _, err := c.conn.Write(in[:]); err != nil {
+		c.disconnect(err)
+	}
 }
 
-func PollButtons(receiver chan<- types.ButtonEvent) {
-	prev := make([][3]bool, config.NumFloors)
+// ensureConn redials the elevator server if the connection is lost and the backoff has passed.
+// Must be called with mtx held
+func (c *TCPClient) ensureConn() bool {
+	if c.conn != nil {
+		return true
+	}
+	if time.Now().Before(c.nextAttempt) {
+		return false
+	}
+
+	conn, err := net.DialTimeout("tcp", c.addr, ioTimeout)
+	if err != nil {
+		c.backoff = min(2*c.backoff, maxBackoff)
+		c.nextAttempt = time.Now().Add(c.backoff)
+		return false
+	}
+	fmt.Println("Reconnected to Elevator Server")
+	c.conn = conn
+	c.backoff = minBackoff
+	return true
+}
+
+// disconnect closes the connection after a socket error. Must be called with mtx held
+func (c *TCPClient) disconnect(err error) {
+	fmt.Println("Lost connection to Elevator Server:", err)
+	c.conn.Close()
+	c.conn = nil
+	c.nextAttempt = time.Now().Add(c.backoff)
+}
+
+func PollButtons(elevIO ElevatorIO, numFloors int, pollRate time.Duration, receiver chan<- types.ButtonEvent) {
+	prev := make([][config.NumButtons]bool, numFloors)
 	for {
-		time.Sleep(config.SensorPollRate)
-		for f := 0; f < config.NumFloors; f++ {
-			for b := types.ButtonType(0); b < 3; b++ {
-				v := GetButton(b, f)
+		time.Sleep(pollRate)
+		for f := 0; f < numFloors; f++ {
+			for b := types.ButtonType(0); b < config.NumButtons; b++ {
+				v := elevIO.GetButton(b, f)
 				if v != prev[f][b] && v {
 					receiver <- types.ButtonEvent{
 						Floor:  f,
@@ -72,11 +194,11 @@ func PollButtons(receiver chan<- types.ButtonEvent) {
 	}
 }
 
-func PollFloorSensor(receiver chan<- int) {
+func PollFloorSensor(elevIO ElevatorIO, pollRate time.Duration, receiver chan<- int) {
 	prev := -1
 	for {
-		time.Sleep(config.SensorPollRate)
-		v := GetFloor()
+		time.Sleep(pollRate)
+		v := elevIO.GetFloor()
 		if v != prev && v != -1 {
 			receiver <- v
 		}
@@ -84,11 +206,11 @@ func PollFloorSensor(receiver chan<- int) {
 	}
 }
 
-func PollStopButton(receiver chan<- bool) {
+func PollStopButton(elevIO ElevatorIO, pollRate time.Duration, receiver chan<- bool) {
 	prev := false
 	for {
-		time.Sleep(config.SensorPollRate)
-		v := GetStop()
+		time.Sleep(pollRate)
+		v := elevIO.GetStop()
 		if v != prev {
 			receiver <- v
 		}
@@ -96,11 +218,12 @@ func PollStopButton(receiver chan<- bool) {
 	}
 }
 
-func PollObstructionSwitch(receiver chan<- bool) {
-	prev := false
+// PollConnection sends false when the connection to the elevator server is lost, and true when it is restored
+func PollConnection(elevIO ElevatorIO, pollRate time.Duration, receiver chan<- bool) {
+	prev := true
 	for {
-		time.Sleep(config.SensorPollRate)
-		v := GetObstruction()
+		time.Sleep(pollRate)
+		v := elevIO.Connected()
 		if v != prev {
 			receiver <- v
 		}
@@ -108,55 +231,15 @@ func PollObstructionSwitch(receiver chan<- bool) {
 	}
 }
 
-func GetButton(button types.ButtonType, floor int) bool {
-	a := read([4]byte{6, byte(button), byte(floor), 0})
-	return toBool(a[1])
-}
-
-func GetFloor() int {
-	a := read([4]byte{7, 0, 0, 0})
-	if a[1] != 0 {
-		return int(a[2])
-	} else {
-		return -1
-	}
-}
-
-func GetStop() bool {
-	a := read([4]byte{8, 0, 0, 0})
-	return toBool(a[1])
-}
-
-func GetObstruction() bool {
-	a := read([4]byte{9, 0, 0, 0})
-	return toBool(a[1])
-}
-
-func read(in [4]byte) [4]byte {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-
-	_, err := _conn.Write(in[:])
-	if err != nil {
-		panic("Lost connection to Elevator Server")
-	}
-
-	var out [4]byte
-	_, err = _conn.Read(out[:])
-	if err != nil {
-		panic("Lost connection to Elevator Server")
-	}
-
-	return out
-}
-
-func write(in [4]byte) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-
-	_, err := _conn.Write(in[:])
-	if err != nil {
-		panic("Lost connection to Elevator Server")
+func PollObstructionSwitch(elevIO ElevatorIO, pollRate time.Duration, receiver chan<- bool) {
+	prev := false
+	for {
+		time.Sleep(pollRate)
+		v := elevIO.GetObstruction()
+		if v != prev {
+			receiver <- v
+		}
+		prev = v
 	}
 }
 
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync/atomic"
+)
+
+// Packets are signed with HMAC-SHA256 of a pre-shared cluster key, truncated to tagSize bytes
+// and appended to the packet. Packets with a missing or wrong tag are dropped and counted.
+// A nil *Authenticator disables authentication, and passes packets through unchanged.
+
+const tagSize = 16
+
+type Authenticator struct {
+	key     []byte
+	dropped atomic.Uint64
+}
+
+// New returns an authenticator for the cluster key, or nil if the key is empty
+func New(key string) *Authenticator {
+	if key == "" {
+		return nil
+	}
+	return &Authenticator{key: []byte(key)}
+}
+
+// Overhead is the number of bytes Sign adds to a packet
+func (a *Authenticator) Overhead() int {
+	if a == nil {
+		return 0
+	}
+	return tagSize
+}
+
+// Sign returns the packet with its tag appended
+func (a *Authenticator) Sign(packet []byte) []byte {
+	if a == nil {
+		return packet
+	}
+	return append(packet[:len(packet):len(packet)], a.tag(packet)...)
+}
+
+// Verify returns the packet without its tag, or false if the tag is wrong
+func (a *Authenticator) Verify(packet []byte) ([]byte, bool) {
+	if a == nil {
+		return packet, true
+	}
+	if len(packet) >= tagSize {
+		payload, tag := packet[:len(packet)-tagSize], packet[len(packet)-tagSize:]
+		if hmac.Equal(tag, a.tag(payload)) {
+			return payload, true
+		}
+	}
+	if dropped := a.dropped.Add(1); dropped&(dropped-1) == 0 { // Logged at powers of two, so a flood of bad packets stays readable
+		fmt.Printf("auth: dropped unauthenticated packet (%d dropped in total)\n", dropped)
+	}
+	return nil, false
+}
+
+// Dropped returns the number of packets that failed verification
+func (a *Authenticator) Dropped() uint64 {
+	if a == nil {
+		return 0
+	}
+	return a.dropped.Load()
+}
+
+func (a *Authenticator) tag(payload []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:tagSize]
+}
@@ -3,155 +3,149 @@ package bcast
 import (
 	"encoding/json"
 	"fmt"
-	"net"
-	"reflect"
+	"time"
 
-	"multivator/lib/network/conn"
+	"multivator/lib/network/auth"
+	"multivator/lib/network/namespace"
+	"multivator/lib/network/transport"
 )
 
 const bufSize = 1024
 
-// Encodes received values from `chans` into type-tagged JSON, then broadcasts
-// it on `port`
-func Transmitter(port int, chans ...interface{}) {
-	checkArgs(chans...)
-	typeNames := make([]string, len(chans))
-	selectCases := make([]reflect.SelectCase, len(typeNames))
-	for i, ch := range chans {
-		selectCases[i] = reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(ch),
-		}
-		typeNames[i] = reflect.TypeOf(ch).Elem().String()
+// Encodes values sent on the Tx channel of each topic into topic-tagged JSON or binary, as currently
+// selected by `encoding`, see codec.go, then sends it to `port` of all nodes through `tr`. Packets longer than the buffer size
+// are sent in fragments, see fragment.go. Each packet is tagged with `cluster`,
+// and signed by `authenticator`, which may be nil
+func Transmitter(tr transport.Transport, port int, cluster string, encoding *EncodingSelector, authenticator *auth.Authenticator, topics ...Topic) {
+	checkTopics(topics)
+	packetCh := make(chan []byte)
+	for _, topic := range topics {
+		go topic.transmit(encoding, packetCh)
 	}
 
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
-	for {
-		chosen, value, _ := reflect.Select(selectCases)
-		jsonstr, _ := json.Marshal(value.Interface())
-		ttj, _ := json.Marshal(typeTaggedJSON{
-			TypeId: typeNames[chosen],
-			JSON:   jsonstr,
-		})
-		if len(ttj) > bufSize {
-			panic(fmt.Sprintf(
-				"Tried to send a message longer than the buffer size (length: %d, buffer size: %d)\n\t'%s'\n"+
-					"Either send smaller packets, or go to network/bcast/bcast.go and increase the buffer size",
-				len(ttj), bufSize, string(ttj)))
+	fragments := newFragmenter()
+	sender, err := tr.Dial(port)
+	if err != nil {
+		panic(fmt.Sprintf("bcast.Transmitter(%d, ...):Dial() failed: \"%+v\"", port, err))
+	}
+	for ttj := range packetCh {
+		packets := [][]byte{ttj}
+		if maxSize := bufSize - namespace.Overhead(cluster) - authenticator.Overhead(); len(ttj) > maxSize {
+			packets, err = fragments.split(ttj, maxSize)
+			if err != nil {
+				fmt.Printf("bcast.Transmitter(%d, ...):split() failed: \"%+v\"\n", port, err)
+				continue
+			}
 		}
-		_, err := conn.WriteTo(ttj, addr)
-		if err != nil {
-			fmt.Printf("bcast.Transmitter(%d, ...):WriteTo() failed: \"%+v\"\n", port, err)
+		for _, packet := range packets {
+			err := sender.Send(authenticator.Sign(namespace.Wrap(cluster, packet)))
+			if err != nil {
+				fmt.Printf("bcast.Transmitter(%d, ...):Send() failed: \"%+v\"\n", port, err)
+			}
 		}
-
 	}
 }
 
-// Matches type-tagged JSON received on `port` to element types of `chans`, then
-// sends the decoded value on the corresponding channel
-func Receiver(port int, chans ...interface{}) {
-	checkArgs(chans...)
-	chansMap := make(map[string]interface{})
-	for _, ch := range chans {
-		chansMap[reflect.TypeOf(ch).Elem().String()] = ch
+// Drops packets not signed by `authenticator`, which may be nil, or from
+// another cluster than `cluster`. Reassembles fragments, and matches topic-tagged
+// JSON or binary received on `port` through `tr` to `topics`,
+// then sends the decoded value on the Rx channel of the topic
+func Receiver(tr transport.Transport, port int, cluster string, authenticator *auth.Authenticator, topics ...Topic) {
+	checkTopics(topics)
+	namesMap := make(map[string]Topic)
+	tagsMap := make(map[uint32]Topic)
+	for _, topic := range topics {
+		namesMap[topic.topic()] = topic
+		tagsMap[topicTag(topic.topic())] = topic
 	}
 
 	var buf [bufSize]byte
-	conn := conn.DialBroadcastUDP(port)
+	fragments := newReassembler()
+	conn, err := tr.Listen(port)
+	if err != nil {
+		panic(fmt.Sprintf("bcast.Receiver(%d, ...):Listen() failed: \"%+v\"", port, err))
+	}
 	for {
 		n, _, e := conn.ReadFrom(buf[0:])
 		if e != nil {
 			fmt.Printf("bcast.Receiver(%d, ...):ReadFrom() failed: \"%+v\"\n", port, e)
+			continue
+		}
+
+		packet, ok := authenticator.Verify(buf[0:n])
+		if !ok {
+			continue
+		}
+		if packet, ok = namespace.Unwrap(cluster, packet); !ok {
+			continue
+		}
+		if len(packet) > 0 && packet[0] == fragmentMagic {
+			packet, err = fragments.add(packet, time.Now())
+			if err != nil {
+				fmt.Printf("bcast.Receiver(%d, ...):add() failed: \"%+v\"\n", port, err)
+			}
+			if packet == nil {
+				continue
+			}
+		}
+
+		if len(packet) > 0 && packet[0] == binaryMagic {
+			tag, decode, err := unmarshalBinary(packet)
+			if err != nil {
+				fmt.Printf("bcast.Receiver(%d, ...):unmarshalBinary() failed: \"%+v\"\n", port, err)
+				continue
+			}
+			topic, ok := tagsMap[tag]
+			if !ok {
+				continue
+			}
+			if err := topic.receiveBinary(decode); err != nil {
+				fmt.Printf("bcast.Receiver(%d, ...):unmarshalBinary() failed: \"%+v\"\n", port, err)
+			}
+			continue
 		}
 
-		var ttj typeTaggedJSON
-		err := json.Unmarshal(buf[0:n], &ttj)
-		if err != nil {
-			fmt.Printf("bcast.Receiver(%d, ...):json.Unmarshal() failed: \"%+v\"\n", port, e)
+		var ttj topicTaggedJSON
+		if err := json.Unmarshal(packet, &ttj); err != nil {
+			fmt.Printf("bcast.Receiver(%d, ...):json.Unmarshal() failed: \"%+v\"\n", port, err)
+			continue
 		}
-		ch, ok := chansMap[ttj.TypeId]
+		topic, ok := namesMap[ttj.Topic]
 		if !ok {
 			continue
 		}
-		v := reflect.New(reflect.TypeOf(ch).Elem())
-		err = json.Unmarshal(ttj.JSON, v.Interface())
-		if err != nil {
-			fmt.Printf("bcast.Receiver(%d, ...):json.Unmarshal() failed: \"%+v\"\n", port, e)
+		if err := topic.receiveJSON(ttj.JSON); err != nil {
+			fmt.Printf("bcast.Receiver(%d, ...):json.Unmarshal() failed: \"%+v\"\n", port, err)
 		}
-		reflect.Select([]reflect.SelectCase{{
-			Dir:  reflect.SelectSend,
-			Chan: reflect.ValueOf(ch),
-			Send: reflect.Indirect(v),
-		}})
 	}
 }
 
-type typeTaggedJSON struct {
-	TypeId string
-	JSON   []byte
+type topicTaggedJSON struct {
+	Topic string
+	JSON  []byte
 }
 
 // Checks that args to Tx'er/Rx'er are valid:
 //
-//	All args must be channels
-//	Element types of channels must be encodable with JSON
-//	No element types are repeated
-//
-// Implementation note:
-//   - Why there is no `isMarshalable()` function in encoding/json is a mystery,
-//     so the tests on element type are hand-copied from `encoding/json/encode.go`
-func checkArgs(chans ...interface{}) {
-	n := 0
-	for range chans {
-		n++
-	}
-	elemTypes := make([]reflect.Type, n)
-
-	for i, ch := range chans {
-		// Must be a channel
-		if reflect.ValueOf(ch).Kind() != reflect.Chan {
-			panic(fmt.Sprintf(
-				"Argument must be a channel, got '%s' instead (arg# %d)",
-				reflect.TypeOf(ch).String(), i+1))
+//	Topic names are not empty
+//	No topic names are repeated
+//	No two topics have the same binary topic tag
+func checkTopics(topics []Topic) {
+	for i, topic := range topics {
+		if topic.topic() == "" {
+			panic(fmt.Sprintf("Topic name must not be empty (arg# %d)", i+1))
 		}
-
-		elemType := reflect.TypeOf(ch).Elem()
-
-		// Element type must not be repeated
-		for j, e := range elemTypes {
-			if e == elemType {
+		for j, other := range topics[:i] {
+			if other.topic() == topic.topic() {
 				panic(fmt.Sprintf(
-					"All channels must have mutually different element types, arg# %d and arg# %d both have element type '%s'",
-					j+1, i+1, e.String()))
+					"All topics must have mutually different names, arg# %d and arg# %d are both named '%s'",
+					j+1, i+1, topic.topic()))
+			}
+			if topicTag(other.topic()) == topicTag(topic.topic()) {
+				panic(fmt.Sprintf(
+					"Topics of arg# %d and arg# %d have the same binary topic tag, rename one of '%s' and '%s'",
+					j+1, i+1, other.topic(), topic.topic()))
 			}
-		}
-		elemTypes[i] = elemType
-
-		// Element type must be encodable with JSON
-		checkTypeRecursive(elemType, []int{i + 1})
-
-	}
-}
-
-func checkTypeRecursive(val reflect.Type, offsets []int) {
-	switch val.Kind() {
-	case reflect.Complex64, reflect.Complex128, reflect.Chan, reflect.Func, reflect.UnsafePointer:
-		panic(fmt.Sprintf(
-			"Channel element type must be supported by JSON, got '%s' instead (nested arg# %v)",
-			val.String(), offsets))
-	case reflect.Map:
-		if val.Key().Kind() != reflect.String {
-			panic(fmt.Sprintf(
-				"Channel element type must be supported by JSON, got '%s' instead (map keys must be 'string') (nested arg# %v)",
-				val.String(), offsets))
-		}
-		checkTypeRecursive(val.Elem(), offsets)
-	case reflect.Array, reflect.Ptr, reflect.Slice:
-		checkTypeRecursive(val.Elem(), offsets)
-	case reflect.Struct:
-		for idx := 0; idx < val.NumField(); idx++ {
-			checkTypeRecursive(val.Field(idx).Type, append(offsets, idx+1))
 		}
 	}
 }
@@ -0,0 +1,75 @@
+package bcast
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// A Channel carries values of one type between all nodes, under a topic name shared by the nodes.
+// Packets are matched to channels by topic, so types can be renamed without breaking the wire format,
+// as long as the topic and the fields stay the same.
+type Channel[T any] struct {
+	Topic string
+	Tx    chan T // Values sent to all nodes, read by Transmitter
+	Rx    chan T // Values received from any node, written by Receiver
+}
+
+// NewChannel returns a channel for topic, with unbuffered Tx and Rx
+func NewChannel[T any](topic string) Channel[T] {
+	return Channel[T]{Topic: topic, Tx: make(chan T), Rx: make(chan T)}
+}
+
+// Topic is implemented by every Channel, so channels of different types can be given to Transmitter and Receiver
+type Topic interface {
+	topic() string
+	// transmit encodes the values sent on Tx, and passes them on to packetCh
+	transmit(encoding *EncodingSelector, packetCh chan<- []byte)
+	// receiveBinary decodes a binary value, and sends it on Rx
+	receiveBinary(decode func(v reflect.Value) error) error
+	// receiveJSON decodes a JSON value, and sends it on Rx
+	receiveJSON(data []byte) error
+}
+
+func (c Channel[T]) topic() string {
+	return c.Topic
+}
+
+func (c Channel[T]) transmit(encoding *EncodingSelector, packetCh chan<- []byte) {
+	tag := topicTag(c.Topic)
+	for value := range c.Tx {
+		var packet []byte
+		var err error
+		if encoding.Get() == Binary {
+			packet, err = marshalBinary(tag, reflect.ValueOf(value))
+		} else {
+			var jsonstr []byte
+			if jsonstr, err = json.Marshal(value); err == nil {
+				packet, err = json.Marshal(topicTaggedJSON{Topic: c.Topic, JSON: jsonstr})
+			}
+		}
+		if err != nil {
+			fmt.Printf("bcast.Transmitter(%q):encode failed: \"%+v\"\n", c.Topic, err)
+			continue
+		}
+		packetCh <- packet
+	}
+}
+
+func (c Channel[T]) receiveBinary(decode func(v reflect.Value) error) error {
+	var value T
+	if err := decode(reflect.ValueOf(&value).Elem()); err != nil {
+		return err
+	}
+	c.Rx <- value
+	return nil
+}
+
+func (c Channel[T]) receiveJSON(data []byte) error {
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	c.Rx <- value
+	return nil
+}
@@ -0,0 +1,344 @@
+package bcast
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sync/atomic"
+)
+
+// Encoding selects the wire format used by Transmitter. Receiver decodes both, so a node should
+// only send Binary once every peer has shown that it can decode it, and JSON otherwise.
+type Encoding int
+
+const (
+	JSON   Encoding = iota // Topic-tagged JSON
+	Binary                 // Compact binary, see below
+)
+
+// ParseEncoding returns the encoding named "json" or "binary"
+func ParseEncoding(name string) (Encoding, error) {
+	switch name {
+	case "json":
+		return JSON, nil
+	case "binary":
+		return Binary, nil
+	}
+	return JSON, fmt.Errorf("unknown encoding %q", name)
+}
+
+// EncodingSelector holds the encoding of Transmitter, and may be changed while it runs.
+// The zero value selects JSON, which every node decodes
+type EncodingSelector struct {
+	encoding atomic.Int32
+}
+
+// Set changes the encoding of values sent from now on
+func (s *EncodingSelector) Set(encoding Encoding) {
+	s.encoding.Store(int32(encoding))
+}
+
+// Get returns the encoding of the next sent value
+func (s *EncodingSelector) Get() Encoding {
+	return Encoding(s.encoding.Load())
+}
+
+// Binary packets are laid out as:
+//   - binaryMagic, which can never start a JSON packet
+//   - 4 byte topic tag, the FNV-1a hash of the topic name, see topicTag
+//   - The value, encoded field by field in declaration order:
+//     bools as one byte, with arrays and slices of bools packed as bits,
+//     integers as varints, floats as their IEEE 754 bits,
+//     strings, slices and maps prefixed with their length,
+//     and types implementing encoding.BinaryMarshaler as their length-prefixed binary form.
+//     Slices, maps and pointers are prefixed with length+1, so 0 means nil.
+const binaryMagic = 0xB1
+
+var (
+	binaryMarshalerType   = reflect.TypeFor[encoding.BinaryMarshaler]()
+	binaryUnmarshalerType = reflect.TypeFor[encoding.BinaryUnmarshaler]()
+	errMalformed          = errors.New("malformed binary packet")
+)
+
+// topicTag identifies a topic in binary packets, without a shared registry between the nodes
+func topicTag(topic string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(topic))
+	return hash.Sum32()
+}
+
+func marshalBinary(tag uint32, value reflect.Value) ([]byte, error) {
+	buf := binary.BigEndian.AppendUint32([]byte{binaryMagic}, tag)
+	return appendValue(buf, value)
+}
+
+// unmarshalBinary returns the topic tag of the packet, and a function that decodes the value into v
+func unmarshalBinary(packet []byte) (uint32, func(v reflect.Value) error, error) {
+	if len(packet) < 5 || packet[0] != binaryMagic {
+		return 0, nil, errMalformed
+	}
+	tag := binary.BigEndian.Uint32(packet[1:5])
+	decode := func(v reflect.Value) error {
+		d := decoder{buf: packet[5:]}
+		if err := d.value(v); err != nil {
+			return err
+		}
+		if len(d.buf) > 0 {
+			return errMalformed
+		}
+		return nil
+	}
+	return tag, decode, nil
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	t := v.Type()
+	if t.Implements(binaryMarshalerType) && reflect.PointerTo(t).Implements(binaryUnmarshalerType) {
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		return append(buf, data...), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Float32:
+		return binary.BigEndian.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len())+1)
+		return appendElems(buf, v)
+	case reflect.Array:
+		return appendElems(buf, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len())+1)
+		var err error
+		for iter := v.MapRange(); iter.Next(); {
+			if buf, err = appendValue(buf, iter.Key()); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	case reflect.Struct:
+		var err error
+		for i := range t.NumField() {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if buf, err = appendValue(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("binary encoding does not support %s", t)
+}
+
+func appendElems(buf []byte, v reflect.Value) ([]byte, error) {
+	if v.Type().Elem().Kind() == reflect.Bool {
+		packed := make([]byte, (v.Len()+7)/8)
+		for i := range v.Len() {
+			if v.Index(i).Bool() {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append(buf, packed...), nil
+	}
+	var err error
+	for i := range v.Len() {
+		if buf, err = appendValue(buf, v.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	value, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errMalformed
+	}
+	d.buf = d.buf[n:]
+	return value, nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)) {
+		return nil, errMalformed
+	}
+	data := d.buf[:n]
+	d.buf = d.buf[n:]
+	return data, nil
+}
+
+// length reads a length+1 prefix, and returns false for nil.
+//   - Every element takes at least one bit, which bounds the allocation for malformed packets
+func (d *decoder) length() (int, bool, error) {
+	n, err := d.uvarint()
+	if err != nil || n == 0 {
+		return 0, false, err
+	}
+	if n-1 > uint64(len(d.buf))*8 {
+		return 0, false, errMalformed
+	}
+	return int(n - 1), true, nil
+}
+
+func (d *decoder) value(v reflect.Value) error {
+	t := v.Type()
+	if t.Implements(binaryMarshalerType) && reflect.PointerTo(t).Implements(binaryUnmarshalerType) {
+		n, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		data, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := d.bytes(1)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, n := binary.Varint(d.buf)
+		if n <= 0 || v.OverflowInt(value) {
+			return errMalformed
+		}
+		d.buf = d.buf[n:]
+		v.SetInt(value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value, err := d.uvarint()
+		if err != nil || v.OverflowUint(value) {
+			return errMalformed
+		}
+		v.SetUint(value)
+	case reflect.Float32:
+		b, err := d.bytes(4)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+	case reflect.Float64:
+		b, err := d.bytes(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case reflect.String:
+		n, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		b, err := d.bytes(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, ok, err := d.length()
+		if err != nil || !ok {
+			return err
+		}
+		v.Set(reflect.MakeSlice(t, n, n))
+		return d.elems(v)
+	case reflect.Array:
+		return d.elems(v)
+	case reflect.Map:
+		n, ok, err := d.length()
+		if err != nil || !ok {
+			return err
+		}
+		v.Set(reflect.MakeMapWithSize(t, n))
+		for range n {
+			key := reflect.New(t.Key()).Elem()
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Pointer:
+		b, err := d.bytes(1)
+		if err != nil || b[0] == 0 {
+			return err
+		}
+		v.Set(reflect.New(t.Elem()))
+		return d.value(v.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			if err := d.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("binary encoding does not support %s", t)
+	}
+	return nil
+}
+
+func (d *decoder) elems(v reflect.Value) error {
+	if v.Type().Elem().Kind() == reflect.Bool {
+		packed, err := d.bytes(uint64(v.Len()+7) / 8)
+		if err != nil {
+			return err
+		}
+		for i := range v.Len() {
+			v.Index(i).SetBool(packed[i/8]&(1<<(i%8)) != 0)
+		}
+		return nil
+	}
+	for i := range v.Len() {
+		if err := d.value(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
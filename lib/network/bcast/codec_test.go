@@ -0,0 +1,135 @@
+package bcast
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stamp implements encoding.BinaryMarshaler, like the order clocks of the dispatcher
+type stamp [2]byte
+
+func (s stamp) MarshalBinary() ([]byte, error) {
+	return s[:], nil
+}
+
+func (s *stamp) UnmarshalBinary(data []byte) error {
+	if len(data) != len(s) {
+		return errors.New("wrong stamp length")
+	}
+	copy(s[:], data)
+	return nil
+}
+
+type inner struct {
+	Floor  int
+	Orders [][3]bool
+}
+
+type codecValue struct {
+	Flag     bool
+	Small    int8
+	Negative int
+	Counter  uint64
+	Ratio    float32
+	Cost     float64
+	Name     string
+	Lamps    []bool
+	Nil      []int
+	Empty    []int
+	Costs    map[string]int
+	Pointer  *inner
+	NilPtr   *inner
+	Inner    inner
+	Stamps   []stamp
+	private  int
+}
+
+func testValue() codecValue {
+	return codecValue{
+		Flag:     true,
+		Small:    -128,
+		Negative: -1 << 40,
+		Counter:  1<<64 - 1,
+		Ratio:    0.5,
+		Cost:     -3.25,
+		Name:     "node-2",
+		Lamps:    []bool{true, false, false, true, true, false, true, false, true},
+		Empty:    []int{},
+		Costs:    map[string]int{"node-0": 3, "node-1": -7},
+		Pointer:  &inner{Floor: 2, Orders: [][3]bool{{true, false, true}}},
+		Inner:    inner{Floor: -1, Orders: [][3]bool{{}, {false, true, false}}},
+		Stamps:   []stamp{{1, 2}, {3, 4}},
+	}
+}
+
+func decodeBinary(packet []byte) (uint32, codecValue, error) {
+	var value codecValue
+	tag, decode, err := unmarshalBinary(packet)
+	if err != nil {
+		return 0, value, err
+	}
+	return tag, value, decode(reflect.ValueOf(&value).Elem())
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	value := testValue()
+	value.private = 5
+	packet, err := marshalBinary(topicTag("sync"), reflect.ValueOf(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, decoded, err := decodeBinary(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != topicTag("sync") {
+		t.Errorf("tag = %x, want %x", tag, topicTag("sync"))
+	}
+	value.private = 0 // Unexported fields are not sent
+	if !reflect.DeepEqual(decoded, value) {
+		t.Errorf("decoded %+v, want %+v", decoded, value)
+	}
+}
+
+func TestBinaryUnsupportedType(t *testing.T) {
+	value := struct{ Callback func() }{}
+	if _, err := marshalBinary(0, reflect.ValueOf(value)); err == nil {
+		t.Error("encoded a func field")
+	}
+}
+
+func TestBinaryMalformed(t *testing.T) {
+	packet, err := marshalBinary(topicTag("sync"), reflect.ValueOf(testValue()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(packet) {
+		if _, _, err := decodeBinary(packet[:n]); err == nil {
+			t.Errorf("decoded a packet truncated to %d of %d bytes", n, len(packet))
+		}
+	}
+
+	wrongMagic := bytes.Clone(packet)
+	wrongMagic[0] = '{'
+	huge := []byte{binaryMagic, 0, 0, 0, 0}
+	huge = append(huge, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0) // Fields up to Lamps
+	huge = append(huge, 0xff, 0xff, 0xff, 0xff, 0x0f)                      // Length of Lamps
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"wrong magic", wrongMagic},
+		{"trailing bytes", append(bytes.Clone(packet), 0)},
+		{"length beyond the packet", huge},
+		{"overflowing int8", []byte{binaryMagic, 0, 0, 0, 0, 0, 0x80, 0x02}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := decodeBinary(test.packet); err == nil {
+				t.Error("decoded a malformed packet")
+			}
+		})
+	}
+}
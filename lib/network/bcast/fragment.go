@@ -0,0 +1,143 @@
+package bcast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Packets longer than bufSize, less the authentication overhead, are split into fragments, and reassembled by the receiver.
+// Each fragment is laid out as:
+//   - fragmentMagic, which can never start a JSON or binary packet
+//   - 4 byte sender, chosen randomly when the transmitter starts
+//   - 4 byte message ID, counting the fragmented messages of the sender
+//   - 2 byte fragment index and 2 byte fragment count
+//   - The next part of the packet
+//
+// Incomplete messages are dropped after reassemblyTimeout, and copies of fragments are ignored.
+const (
+	fragmentMagic     = 0xF4
+	fragmentHeader    = 13
+	maxFragments      = 64
+	reassemblyTimeout = 1 * time.Second
+	maxPartials       = 64 // Incomplete messages kept at once, so lost fragments can not fill the memory
+)
+
+var errMalformedFragment = errors.New("malformed fragment")
+
+type fragmenter struct {
+	sender uint32
+	nextID uint32
+}
+
+func newFragmenter() *fragmenter {
+	return &fragmenter{sender: rand.Uint32()}
+}
+
+// split returns fragments of at most maxSize bytes for a packet that is longer than maxSize
+func (f *fragmenter) split(packet []byte, maxSize int) ([][]byte, error) {
+	chunkSize := maxSize - fragmentHeader
+	count := (len(packet) + chunkSize - 1) / chunkSize
+	if count > maxFragments {
+		return nil, fmt.Errorf("message of %d bytes needs more than %d fragments", len(packet), maxFragments)
+	}
+	f.nextID++
+
+	fragments := make([][]byte, count)
+	for i := range count {
+		fragment := make([]byte, fragmentHeader, maxSize)
+		fragment[0] = fragmentMagic
+		binary.BigEndian.PutUint32(fragment[1:], f.sender)
+		binary.BigEndian.PutUint32(fragment[5:], f.nextID)
+		binary.BigEndian.PutUint16(fragment[9:], uint16(i))
+		binary.BigEndian.PutUint16(fragment[11:], uint16(count))
+		fragments[i] = append(fragment, packet[i*chunkSize:min((i+1)*chunkSize, len(packet))]...)
+	}
+	return fragments, nil
+}
+
+type fragmentKey struct {
+	sender uint32
+	id     uint32
+}
+
+type partial struct {
+	chunks   [][]byte
+	missing  int
+	deadline time.Time
+}
+
+type reassembler struct {
+	partials  map[fragmentKey]*partial
+	completed map[fragmentKey]time.Time // Recently reassembled messages, so late copies of their fragments are ignored
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{
+		partials:  make(map[fragmentKey]*partial),
+		completed: make(map[fragmentKey]time.Time),
+	}
+}
+
+// add stores a fragment, and returns the reassembled packet once all fragments of the message are received
+func (r *reassembler) add(fragment []byte, now time.Time) ([]byte, error) {
+	r.expire(now)
+	if len(fragment) < fragmentHeader || fragment[0] != fragmentMagic {
+		return nil, errMalformedFragment
+	}
+	key := fragmentKey{
+		sender: binary.BigEndian.Uint32(fragment[1:]),
+		id:     binary.BigEndian.Uint32(fragment[5:]),
+	}
+	index := int(binary.BigEndian.Uint16(fragment[9:]))
+	count := int(binary.BigEndian.Uint16(fragment[11:]))
+	if count < 2 || count > maxFragments || index >= count {
+		return nil, errMalformedFragment
+	}
+	if _, done := r.completed[key]; done {
+		return nil, nil
+	}
+
+	p, exists := r.partials[key]
+	if !exists {
+		if len(r.partials) >= maxPartials {
+			return nil, fmt.Errorf("dropped fragment, %d messages are already being reassembled", maxPartials)
+		}
+		p = &partial{chunks: make([][]byte, count), missing: count, deadline: now.Add(reassemblyTimeout)}
+		r.partials[key] = p
+	}
+	if len(p.chunks) != count {
+		return nil, errMalformedFragment
+	}
+	if p.chunks[index] != nil {
+		return nil, nil
+	}
+	p.chunks[index] = append([]byte(nil), fragment[fragmentHeader:]...)
+	p.missing--
+	if p.missing > 0 {
+		return nil, nil
+	}
+
+	delete(r.partials, key)
+	r.completed[key] = now.Add(reassemblyTimeout)
+	var packet []byte
+	for _, chunk := range p.chunks {
+		packet = append(packet, chunk...)
+	}
+	return packet, nil
+}
+
+func (r *reassembler) expire(now time.Time) {
+	for key, p := range r.partials {
+		if now.After(p.deadline) {
+			delete(r.partials, key)
+		}
+	}
+	for key, deadline := range r.completed {
+		if now.After(deadline) {
+			delete(r.completed, key)
+		}
+	}
+}
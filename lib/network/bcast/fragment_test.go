@@ -0,0 +1,172 @@
+package bcast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testPacket(size int) []byte {
+	packet := make([]byte, size)
+	for i := range packet {
+		packet[i] = byte(i * 7)
+	}
+	return packet
+}
+
+// reassemble adds fragments in the given order, and returns every reassembled packet
+func reassemble(t *testing.T, r *reassembler, fragments [][]byte, order []int, now time.Time) [][]byte {
+	t.Helper()
+	var packets [][]byte
+	for _, i := range order {
+		packet, err := r.add(fragments[i], now)
+		if err != nil {
+			t.Fatalf("fragment %d: %v", i, err)
+		}
+		if packet != nil {
+			packets = append(packets, packet)
+		}
+	}
+	return packets
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"in order", []int{0, 1, 2, 3}},
+		{"out of order", []int{2, 0, 3, 1}},
+		{"duplicates", []int{0, 0, 1, 2, 1, 3}},
+		{"duplicates after completion", []int{3, 2, 1, 0, 0, 2}},
+	}
+	packet := testPacket(100)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fragments, err := newFragmenter().split(packet, 40)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fragments) != 4 {
+				t.Fatalf("got %d fragments, want 4", len(fragments))
+			}
+			for i, fragment := range fragments {
+				if len(fragment) > 40 {
+					t.Errorf("fragment %d is %d bytes, want at most 40", i, len(fragment))
+				}
+			}
+			packets := reassemble(t, newReassembler(), fragments, test.order, time.Now())
+			if len(packets) != 1 || !bytes.Equal(packets[0], packet) {
+				t.Errorf("got %d packets, want the original packet once", len(packets))
+			}
+		})
+	}
+}
+
+func TestFragmentInterleavedMessages(t *testing.T) {
+	f := newFragmenter()
+	first, second := testPacket(60), testPacket(70)
+	fragmentsA, _ := f.split(first, 30)
+	fragmentsB, _ := f.split(second, 30)
+
+	r := newReassembler()
+	now := time.Now()
+	var packets [][]byte
+	for i := range max(len(fragmentsA), len(fragmentsB)) {
+		if i < len(fragmentsB) {
+			packets = append(packets, reassemble(t, r, fragmentsB, []int{i}, now)...)
+		}
+		if i < len(fragmentsA) {
+			packets = append(packets, reassemble(t, r, fragmentsA, []int{i}, now)...)
+		}
+	}
+	if len(packets) != 2 || !bytes.Equal(packets[0], first) || !bytes.Equal(packets[1], second) {
+		t.Errorf("got %d packets, want both messages", len(packets))
+	}
+}
+
+func TestFragmentTimeout(t *testing.T) {
+	fragments, _ := newFragmenter().split(testPacket(100), 40)
+	r := newReassembler()
+	start := time.Now()
+	reassemble(t, r, fragments, []int{0, 1, 2}, start)
+
+	late := start.Add(reassemblyTimeout + time.Millisecond)
+	if packets := reassemble(t, r, fragments, []int{3}, late); len(packets) != 0 {
+		t.Error("reassembled a message whose other fragments timed out")
+	}
+	if len(r.partials) != 1 {
+		t.Errorf("%d messages being reassembled, want only the late fragment", len(r.partials))
+	}
+	if packets := reassemble(t, r, fragments, []int{0, 1, 2}, late); len(packets) != 1 {
+		t.Error("a resent message was not reassembled after the timeout")
+	}
+}
+
+func TestFragmentMaxPartials(t *testing.T) {
+	f := newFragmenter()
+	r := newReassembler()
+	now := time.Now()
+	for range maxPartials {
+		fragments, _ := f.split(testPacket(50), 30)
+		reassemble(t, r, fragments, []int{0}, now)
+	}
+	fragments, _ := f.split(testPacket(50), 30)
+	if _, err := r.add(fragments[0], now); err == nil {
+		t.Error("started a new message with maxPartials incomplete messages")
+	}
+	if _, err := r.add(fragments[0], now.Add(reassemblyTimeout+time.Millisecond)); err != nil {
+		t.Errorf("expired messages were not dropped: %v", err)
+	}
+}
+
+func TestSplitTooManyFragments(t *testing.T) {
+	chunkSize := 20 - fragmentHeader
+	if _, err := newFragmenter().split(testPacket(chunkSize*maxFragments+1), 20); err == nil {
+		t.Error("split a message into more than maxFragments fragments")
+	}
+}
+
+func TestFragmentMalformed(t *testing.T) {
+	header := func(index, count uint16) []byte {
+		fragment := make([]byte, fragmentHeader, fragmentHeader+4)
+		fragment[0] = fragmentMagic
+		binary.BigEndian.PutUint32(fragment[1:], 1)
+		binary.BigEndian.PutUint32(fragment[5:], 1)
+		binary.BigEndian.PutUint16(fragment[9:], index)
+		binary.BigEndian.PutUint16(fragment[11:], count)
+		return append(fragment, 1, 2, 3, 4)
+	}
+	wrongMagic := header(0, 2)
+	wrongMagic[0] = '{'
+	tests := []struct {
+		name     string
+		fragment []byte
+	}{
+		{"empty", nil},
+		{"truncated header", header(0, 2)[:fragmentHeader-1]},
+		{"wrong magic", wrongMagic},
+		{"single fragment", header(0, 1)},
+		{"too many fragments", header(0, maxFragments+1)},
+		{"index out of range", header(2, 2)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newReassembler().add(test.fragment, time.Now()); !errors.Is(err, errMalformedFragment) {
+				t.Errorf("got error %v, want %v", err, errMalformedFragment)
+			}
+		})
+	}
+
+	t.Run("count changed within a message", func(t *testing.T) {
+		r := newReassembler()
+		if _, err := r.add(header(0, 2), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.add(header(1, 3), time.Now()); !errors.Is(err, errMalformedFragment) {
+			t.Errorf("got error %v, want %v", err, errMalformedFragment)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin
+
+package conn
+
+import (
+	"net"
+	"syscall"
+)
+
+// SetMulticastTTL sets the number of routers multicast packets sent on conn may pass
+func SetMulticastTTL(conn *net.UDPConn, ttl int) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rawConn.Control(func(descriptor uintptr) {
+		sockErr = syscall.SetsockoptInt(int(descriptor), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
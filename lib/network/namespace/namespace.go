@@ -0,0 +1,37 @@
+package namespace
+
+// Several elevator clusters can share a network and ports by using different namespaces.
+// Packets of a named namespace are prefixed with namespaceMagic, the length of the name, and the name.
+// Packets of the default namespace "" are sent unchanged, as by versions without namespaces.
+// Receivers drop packets of other namespaces.
+
+const namespaceMagic = 0xC5
+
+// Overhead is the number of bytes Wrap adds to packets of the namespace
+func Overhead(namespace string) int {
+	if namespace == "" {
+		return 0
+	}
+	return 2 + len(namespace)
+}
+
+// Wrap returns the packet prefixed with its namespace. Names must be shorter than 256 bytes
+func Wrap(namespace string, packet []byte) []byte {
+	if namespace == "" {
+		return packet
+	}
+	wrapped := append([]byte{namespaceMagic, byte(len(namespace))}, namespace...)
+	return append(wrapped, packet...)
+}
+
+// Unwrap returns the packet without its prefix, or false if it belongs to another namespace
+func Unwrap(namespace string, packet []byte) ([]byte, bool) {
+	if len(packet) == 0 || packet[0] != namespaceMagic {
+		return packet, namespace == ""
+	}
+	if len(packet) < 2 || len(packet) < 2+int(packet[1]) {
+		return nil, false
+	}
+	name, payload := string(packet[2:2+int(packet[1])]), packet[2+int(packet[1]):]
+	return payload, name == namespace
+}
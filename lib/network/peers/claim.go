@@ -0,0 +1,67 @@
+package peers
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"multivator/lib/network/auth"
+	"multivator/lib/network/transport"
+)
+
+// A starting node claims its id before it sends heartbeats, so two processes never run with the same id.
+// The claimant sends claim heartbeats, with Metadata.Claim set to a random number, and listens for the claim duration.
+// The id is taken if a running node sends heartbeats with it, or if another claimant claims it with a lower number,
+// so of two nodes starting at once, exactly one gets the id. Receivers ignore claim heartbeats.
+
+// Claim is called on startup, before Transmitter and Receiver.
+//   - Claims the first candidate that is not taken, and moves on to the next one when it is
+//   - Returns the claimed id, or an error if every candidate is taken
+func Claim(
+	tr transport.Transport, port int, cluster string, authenticator *auth.Authenticator,
+	candidates []NodeID, interval time.Duration, duration time.Duration,
+) (NodeID, error) {
+	conn, err := tr.Listen(port)
+	if err != nil {
+		return NoNode, fmt.Errorf("listen for heartbeats: %w", err)
+	}
+	defer conn.Close()
+	sender, err := tr.Dial(port)
+	if err != nil {
+		return NoNode, fmt.Errorf("dial heartbeats: %w", err)
+	}
+	defer sender.Close()
+
+	var buf [1024]byte
+	claim := rand.Uint64() | 1 // Never 0, which is an ordinary heartbeat
+	taken := make(map[NodeID]bool)
+	lastSent := make(map[NodeID]uint64)
+	for _, candidate := range candidates {
+		deadline := time.Now().Add(duration)
+		var nextSend time.Time
+		for !taken[candidate] && time.Now().Before(deadline) {
+			if now := time.Now(); !now.Before(nextSend) {
+				sendHeartbeat(sender, cluster, candidate, authenticator, Metadata{Claim: claim})
+				nextSend = now.Add(interval)
+			}
+			if err := conn.SetReadDeadline(nextSend); err != nil {
+				fmt.Println("SetReadDeadline error:", err)
+			}
+			n, _, _ := conn.ReadFrom(buf[0:])
+			if n == 0 {
+				continue
+			}
+			id, received := parseHeartbeat(buf[:n], cluster, authenticator, lastSent)
+			if id != NoNode && (received.Claim == 0 || received.Claim < claim) {
+				taken[id] = true
+			}
+		}
+		if !taken[candidate] {
+			return candidate, nil
+		}
+	}
+	if len(candidates) == 1 {
+		return NoNode, fmt.Errorf("%s is already used by another node", candidates[0])
+	}
+	return NoNode, fmt.Errorf("all ids are used by other nodes: %v", candidates)
+}
@@ -0,0 +1,79 @@
+package peers
+
+import (
+	"math"
+	"time"
+)
+
+// Peers are monitored by a phi accrual failure detector. For each peer, the detector keeps the
+// mean and deviation of recent heartbeat inter-arrival times, and computes the suspicion level phi
+// from the time since the last heartbeat: phi = -log10(probability that a heartbeat is still coming).
+// On a jittery link the deviation grows, so peers are given more time before they are lost,
+// instead of flapping as with a fixed timeout.
+//   - phi >= SuspectPhi: the peer is suspected, but still a peer
+//   - phi >= LostPhi: the peer is lost
+
+type Detector struct {
+	Interval        time.Duration // Between sent heartbeats
+	SuspectPhi      float64
+	LostPhi         float64
+	MinDeviation    time.Duration // Lower bound of the inter-arrival deviation, so a steady link does not make the detector hair-triggered
+	AcceptablePause time.Duration // Added to the mean inter-arrival time, so occasional pauses are not suspected
+}
+
+// Number of inter-arrival times kept per peer
+const windowSize = 200
+
+type arrivalWindow struct {
+	last      time.Time
+	intervals [windowSize]float64 // Seconds
+	count     int
+	next      int
+	sum       float64
+	sumSq     float64
+}
+
+// newArrivalWindow is called on the first heartbeat from a peer.
+//   - Starts with two intervals around the expected heartbeat interval, as there are no statistics yet
+func newArrivalWindow(now time.Time, detector Detector) *arrivalWindow {
+	w := &arrivalWindow{last: now}
+	expected := detector.Interval.Seconds()
+	w.push(expected * 0.75)
+	w.push(expected * 1.25)
+	return w
+}
+
+func (w *arrivalWindow) add(now time.Time) {
+	w.push(now.Sub(w.last).Seconds())
+	w.last = now
+}
+
+func (w *arrivalWindow) push(interval float64) {
+	if w.count == windowSize {
+		old := w.intervals[w.next]
+		w.sum -= old
+		w.sumSq -= old * old
+	} else {
+		w.count++
+	}
+	w.intervals[w.next] = interval
+	w.next = (w.next + 1) % windowSize
+	w.sum += interval
+	w.sumSq += interval * interval
+}
+
+// phi returns the suspicion level of the peer at now
+func (w *arrivalWindow) phi(now time.Time, detector Detector) float64 {
+	mean := w.sum / float64(w.count)
+	deviation := math.Sqrt(max(w.sumSq/float64(w.count)-mean*mean, 0))
+	deviation = max(deviation, detector.MinDeviation.Seconds())
+	mean += detector.AcceptablePause.Seconds()
+
+	// Logistic approximation of the normal distribution, as used by Akka
+	y := (now.Sub(w.last).Seconds() - mean) / deviation
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if y > 0 {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
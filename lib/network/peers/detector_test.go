@@ -0,0 +1,98 @@
+package peers
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var testDetector = Detector{
+	Interval:     100 * time.Millisecond,
+	SuspectPhi:   3,
+	LostPhi:      8,
+	MinDeviation: 10 * time.Millisecond,
+}
+
+// arrivals returns a window that has received heartbeats with the given gaps, and the time of the last one
+func arrivals(gaps ...time.Duration) (*arrivalWindow, time.Time) {
+	now := time.Unix(1000, 0)
+	w := newArrivalWindow(now, testDetector)
+	for _, gap := range gaps {
+		now = now.Add(gap)
+		w.add(now)
+	}
+	return w, now
+}
+
+func repeat(gaps []time.Duration, n int) []time.Duration {
+	var repeated []time.Duration
+	for range n {
+		repeated = append(repeated, gaps...)
+	}
+	return repeated
+}
+
+func TestPhi(t *testing.T) {
+	steady := repeat([]time.Duration{100 * time.Millisecond}, 50)
+	jittery := repeat([]time.Duration{50 * time.Millisecond, 150 * time.Millisecond}, 25)
+	tests := []struct {
+		name     string
+		gaps     []time.Duration
+		elapsed  time.Duration
+		min, max float64
+	}{
+		{"steady, just received", steady, 0, 0, 0.01},
+		{"steady, at the mean", steady, 100 * time.Millisecond, math.Log10(2) - 0.01, math.Log10(2) + 0.01},
+		{"steady, slightly late", steady, 120 * time.Millisecond, 1, testDetector.SuspectPhi},
+		{"steady, at twice the mean", steady, 200 * time.Millisecond, testDetector.LostPhi, math.Inf(1)},
+		{"steady, at several times the mean", steady, 500 * time.Millisecond, testDetector.LostPhi, math.Inf(1)},
+		{"jittery, at the mean", jittery, 100 * time.Millisecond, math.Log10(2) - 0.01, math.Log10(2) + 0.01},
+		{"jittery, at twice the mean", jittery, 200 * time.Millisecond, 1, testDetector.SuspectPhi},
+		{"jittery, at several times the mean", jittery, 500 * time.Millisecond, testDetector.LostPhi, math.Inf(1)},
+		{"first heartbeat, at the interval", nil, 100 * time.Millisecond, math.Log10(2) - 0.01, math.Log10(2) + 0.01},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, last := arrivals(test.gaps...)
+			if phi := w.phi(last.Add(test.elapsed), testDetector); phi < test.min || phi > test.max {
+				t.Errorf("phi = %.3f, want between %.3f and %.3f", phi, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestPhiAcceptablePause(t *testing.T) {
+	w, last := arrivals(repeat([]time.Duration{100 * time.Millisecond}, 50)...)
+	detector := testDetector
+	detector.AcceptablePause = 100 * time.Millisecond
+	if phi := w.phi(last.Add(200*time.Millisecond), detector); math.Abs(phi-math.Log10(2)) > 0.01 {
+		t.Errorf("phi = %.3f after the mean and the acceptable pause, want %.3f", phi, math.Log10(2))
+	}
+}
+
+func TestNewArrivalWindow(t *testing.T) {
+	w := newArrivalWindow(time.Unix(1000, 0), testDetector)
+	if w.count != 2 {
+		t.Fatalf("count = %d, want 2", w.count)
+	}
+	if mean := w.sum / float64(w.count); math.Abs(mean-testDetector.Interval.Seconds()) > 1e-9 {
+		t.Errorf("mean = %v, want the heartbeat interval %v", mean, testDetector.Interval.Seconds())
+	}
+}
+
+func TestArrivalWindowWraparound(t *testing.T) {
+	// The slow gaps push every earlier interval out of the window
+	gaps := append(repeat([]time.Duration{100 * time.Millisecond}, windowSize), repeat([]time.Duration{200 * time.Millisecond}, windowSize)...)
+	w, last := arrivals(gaps...)
+	if w.count != windowSize {
+		t.Fatalf("count = %d, want %d", w.count, windowSize)
+	}
+	mean := w.sum / float64(w.count)
+	variance := w.sumSq/float64(w.count) - mean*mean
+	if math.Abs(mean-0.2) > 1e-9 || math.Abs(variance) > 1e-9 {
+		t.Errorf("mean %v and variance %v, want only the 200ms gaps", mean, variance)
+	}
+	if phi := w.phi(last.Add(200*time.Millisecond), testDetector); math.Abs(phi-math.Log10(2)) > 0.01 {
+		t.Errorf("phi = %.3f at the new mean, want %.3f", phi, math.Log10(2))
+	}
+}
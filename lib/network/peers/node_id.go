@@ -0,0 +1,27 @@
+package peers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NodeID identifies a node. Heartbeats carry it as "node-<id>"
+type NodeID int
+
+// NoNode is PeerUpdate.New when no peer is new
+const NoNode NodeID = -1
+
+func (id NodeID) String() string {
+	return "node-" + strconv.Itoa(int(id))
+}
+
+// ParseNodeID returns the node id in s, or an error if s is not "node-" followed by a non-negative integer without leading zeros
+func ParseNodeID(s string) (NodeID, error) {
+	digits, found := strings.CutPrefix(s, "node-")
+	n, err := strconv.Atoi(digits)
+	if !found || err != nil || n < 0 || strconv.Itoa(n) != digits {
+		return NoNode, fmt.Errorf("invalid node id %q, must be node-<n> with n >= 0", s)
+	}
+	return NodeID(n), nil
+}
@@ -1,81 +1,193 @@
 package peers
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
-	"net"
-	"sort"
+	"maps"
+	"slices"
 	"time"
 
-	"multivator/lib/network/conn"
+	"multivator/lib/network/auth"
+	"multivator/lib/network/namespace"
+	"multivator/lib/network/transport"
 )
 
 type PeerUpdate struct {
-	Peers []string
-	New   string
-	Lost  []string
+	Peers     []NodeID
+	New       NodeID              // NoNode if no peer is new
+	Lost      []NodeID            // Confirmed by the failure detector, see detector.go
+	Suspected []NodeID            // Peers that may be lost, but are still in Peers
+	Suspicion map[NodeID]float64  // Suspicion level of each peer in Peers, as of this update
+	Metadata  map[NodeID]Metadata // Latest metadata of each peer in Peers
 }
 
-const (
-	interval = 15 * time.Millisecond
-	timeout  = 500 * time.Millisecond
-)
+// Metadata is sent with every heartbeat, so nodes know the state of their peers between other messages
+type Metadata struct {
+	Version    string
+	Floor      int
+	Behaviour  string
+	Stuck      bool
+	Obstructed bool
+	Orders     int           // Number of orders assigned to the node
+	Uptime     time.Duration // Set by Transmitter. As of the last update sent on the peer update channel
+	Leaving    bool          // Set by Transmitter on the last heartbeats before it is disabled
+	Claim      uint64        // Set on the heartbeats of a starting node that claims its id, see claim.go
+	Binary     bool          // The node decodes the binary bcast encoding
+}
+
+// sameState returns true if a and b only differ in uptime, so receivers do not send an update for every heartbeat
+func (a Metadata) sameState(b Metadata) bool {
+	a.Uptime, b.Uptime = 0, 0
+	return a == b
+}
 
-func Transmitter(port int, id string, transmitEnable <-chan bool) {
-	conn := conn.DialBroadcastUDP(port)
-	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+// Heartbeats are the id as "node-<id>", followed by a zero byte and the metadata as JSON. Heartbeats of older versions
+// are the plain id, and are received with empty metadata. They are tagged with the cluster namespace. With an authenticator, they are signed,
+// and the id is prefixed with the 8 byte send time in nanoseconds. Receivers drop heartbeats that are not
+// newer than the last one from the same id, so recorded heartbeats can not be replayed to keep a lost peer alive.
+// Heartbeats from other clusters, or with an invalid id, are dropped, so their nodes never appear as peers.
+
+// Number of goodbye heartbeats sent when the transmitter is disabled, in case some are lost
+const goodbyes = 3
+
+// Transmitter sends heartbeats every interval, with the latest metadata from metadataCh.
+//   - Metadata changes wait for the next heartbeat, since extra heartbeats would shorten the inter-arrival
+//     times measured by the failure detector, and make later regular gaps look suspicious
+//   - When disabled through transmitEnable, it sends goodbye heartbeats so receivers see a clean departure
+//     at once, instead of waiting for the failure detector
+func Transmitter(
+	tr transport.Transport, port int, cluster string, id NodeID, authenticator *auth.Authenticator,
+	interval time.Duration, transmitEnable <-chan bool, metadataCh <-chan Metadata,
+) {
+	sender, err := tr.Dial(port)
+	if err != nil {
+		panic(fmt.Sprintf("peers.Transmitter(%d, ...):Dial() failed: %v", port, err))
+	}
 
+	started := time.Now()
+	var metadata Metadata
 	enable := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
+		wasEnabled := enable
 		select {
 		case enable = <-transmitEnable:
-		case <-time.After(interval):
+		case metadata = <-metadataCh:
+			continue
+		case <-ticker.C:
 		}
+		metadata.Uptime = time.Since(started).Truncate(time.Millisecond)
 		if enable {
-			if _, err := conn.WriteTo([]byte(id), addr); err != nil {
-				fmt.Println("WriteTo error:", err)
+			sendHeartbeat(sender, cluster, id, authenticator, metadata)
+		} else if wasEnabled {
+			goodbye := metadata
+			goodbye.Leaving = true
+			for range goodbyes {
+				sendHeartbeat(sender, cluster, id, authenticator, goodbye)
 			}
 		}
 	}
 }
 
-func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
+func sendHeartbeat(sender transport.Sender, cluster string, id NodeID, authenticator *auth.Authenticator, metadata Metadata) {
+	encoded, _ := json.Marshal(metadata)
+	var heartbeat []byte
+	if authenticator != nil {
+		heartbeat = binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	}
+	heartbeat = append(heartbeat, id.String()...)
+	heartbeat = append(append(heartbeat, 0), encoded...)
+	heartbeat = authenticator.Sign(namespace.Wrap(cluster, heartbeat))
+	if err := sender.Send(heartbeat); err != nil {
+		fmt.Println("Send error:", err)
+	}
+}
+
+// Receiver sends a peer update when a peer is new, lost, suspected or no longer suspected, or when its metadata changes
+func Receiver(
+	tr transport.Transport, port int, cluster string, authenticator *auth.Authenticator,
+	detector Detector, peerUpdateCh chan<- PeerUpdate,
+) {
 	var buf [1024]byte
 	var p PeerUpdate
-	lastSeen := make(map[string]time.Time)
-	allLost := make(map[string]bool)
-
-	conn := conn.DialBroadcastUDP(port)
+	arrivals := make(map[NodeID]*arrivalWindow)
+	allLost := make(map[NodeID]bool)
+	lastSent := make(map[NodeID]uint64)
+	metadata := make(map[NodeID]Metadata)
+
+	conn, err := tr.Listen(port)
+	if err != nil {
+		panic(fmt.Sprintf("peers.Receiver(%d, ...):Listen() failed: %v", port, err))
+	}
 
 	for {
 		updated := false
 
-		if err := conn.SetReadDeadline(time.Now().Add(interval)); err != nil {
+		if err := conn.SetReadDeadline(time.Now().Add(detector.Interval)); err != nil {
 			fmt.Println("SetReadDeadline error:", err)
 		}
 		n, _, _ := conn.ReadFrom(buf[0:])
 
-		id := string(buf[:n])
+		id := NoNode
+		var received Metadata
+		if n > 0 {
+			id, received = parseHeartbeat(buf[:n], cluster, authenticator, lastSent)
+		}
+		if received.Claim != 0 {
+			id = NoNode // The claimant is not a peer yet
+		}
 
 		// Adding new connection
-		p.New = ""
-		if id != "" {
-			if _, idExists := lastSeen[id]; !idExists {
+		p.New = NoNode
+		now := time.Now()
+		if id != NoNode && received.Leaving {
+			// Goodbye heartbeat, the peer is lost at once
+			if _, idExists := arrivals[id]; idExists {
+				updated = true
+				allLost[id] = true
+				delete(arrivals, id)
+				delete(metadata, id)
+			}
+		} else if id != NoNode {
+			if window, idExists := arrivals[id]; idExists {
+				window.add(now)
+			} else {
 				p.New = id
 				updated = true
 				delete(allLost, id)
+				arrivals[id] = newArrivalWindow(now, detector)
+			}
+			if p.New == id || !received.sameState(metadata[id]) {
+				metadata[id] = received
+				updated = true
 			}
-			lastSeen[id] = time.Now()
 		}
 
-		// Removing dead connection
-		p.Lost = make([]string, 0)
-		for k, v := range lastSeen {
-			if time.Since(v) > timeout {
+		// Removing dead connection, and suspecting late ones
+		p.Lost = make([]NodeID, 0)
+		suspicion := make(map[NodeID]float64, len(arrivals))
+		suspected := make([]NodeID, 0)
+		for k, window := range arrivals {
+			phi := window.phi(now, detector)
+			if phi >= detector.LostPhi {
 				updated = true
 				allLost[k] = true
-				delete(lastSeen, k)
+				delete(arrivals, k)
+				delete(metadata, k)
+				continue
+			}
+			suspicion[k] = phi
+			if phi >= detector.SuspectPhi {
+				suspected = append(suspected, k)
 			}
 		}
+		slices.Sort(suspected)
+		if !slices.Equal(suspected, p.Suspected) {
+			updated = true
+		}
 
 		for k := range allLost {
 			p.Lost = append(p.Lost, k)
@@ -83,14 +195,58 @@ func Receiver(port int, peerUpdateCh chan<- PeerUpdate) {
 
 		// Sending update
 		if updated {
-			p.Peers = make([]string, 0, len(lastSeen))
-			for k := range lastSeen {
+			p.Peers = make([]NodeID, 0, len(arrivals))
+			for k := range arrivals {
 				p.Peers = append(p.Peers, k)
 			}
 
-			sort.Strings(p.Peers)
-			sort.Strings(p.Lost)
+			slices.Sort(p.Peers)
+			slices.Sort(p.Lost)
+			p.Suspected = suspected
+			p.Suspicion = suspicion
+			p.Metadata = maps.Clone(metadata)
 			peerUpdateCh <- p
 		}
 	}
 }
+
+// parseHeartbeat returns the id and metadata of a heartbeat, or NoNode if it is dropped
+func parseHeartbeat(packet []byte, cluster string, authenticator *auth.Authenticator, lastSent map[NodeID]uint64) (NodeID, Metadata) {
+	heartbeat, ok := authenticator.Verify(packet)
+	if !ok {
+		return NoNode, Metadata{}
+	}
+	if heartbeat, ok = namespace.Unwrap(cluster, heartbeat); !ok {
+		return NoNode, Metadata{}
+	}
+	var sent uint64
+	if authenticator != nil {
+		if len(heartbeat) <= 8 {
+			return NoNode, Metadata{}
+		}
+		sent = binary.BigEndian.Uint64(heartbeat)
+		heartbeat = heartbeat[8:]
+	}
+
+	name, encoded, hasMetadata := bytes.Cut(heartbeat, []byte{0})
+	id, err := ParseNodeID(string(name))
+	if err != nil {
+		return NoNode, Metadata{}
+	}
+	var metadata Metadata
+	if hasMetadata {
+		if err := json.Unmarshal(encoded, &metadata); err != nil {
+			return NoNode, Metadata{}
+		}
+	}
+	if authenticator != nil {
+		if sent <= lastSent[id] {
+			return NoNode, Metadata{}
+		}
+		// Claims are sent by another process, so their send times must not hold back the heartbeats of the running node
+		if metadata.Claim == 0 {
+			lastSent[id] = sent
+		}
+	}
+	return id, metadata
+}
@@ -0,0 +1,137 @@
+package reliable
+
+import (
+	"slices"
+	"time"
+)
+
+// Reliable delivery on top of bcast: every message is retransmitted until each peer has acked it,
+// or has been lost according to the peer list. Receivers ack every copy, and deliver each message once.
+// Envelope[T] and Ack[T] are sent through bcast, so every message type has its own acks.
+
+// Envelope wraps a message with the sender and a sequence number.
+//   - Session changes when the sender restarts, so restarted senders are not taken as duplicates
+type Envelope[T any] struct {
+	From    string
+	Session int64
+	Seq     uint64
+	Msg     T
+}
+
+// Ack is sent by From for every received copy of envelope Seq from To
+type Ack[T any] struct {
+	From    string
+	To      string
+	Session int64
+	Seq     uint64
+}
+
+// Number of sequence numbers remembered per sender for duplicate detection
+const window = 1024
+
+type pending[T any] struct {
+	envelope Envelope[T]
+	waiting  []string // Peers that have not acked yet
+}
+
+// Transmitter sends each message from msgCh as an envelope on envelopeTxCh.
+//   - Waits for acks from the peers in the latest list on peersCh, which should include ownID once connected
+//   - Retransmits unacked envelopes every interval
+//   - Gives up on a peer when it is no longer in the peer list
+func Transmitter[T any](
+	ownID string,
+	interval time.Duration,
+	msgCh <-chan T,
+	envelopeTxCh chan<- Envelope[T],
+	ackRxCh <-chan Ack[T],
+	peersCh <-chan []string,
+) {
+	session := time.Now().UnixNano()
+	var seq uint64
+	var peers []string
+	var unacked []*pending[T]
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case msg := <-msgCh:
+			seq++
+			p := &pending[T]{
+				envelope: Envelope[T]{From: ownID, Session: session, Seq: seq, Msg: msg},
+			}
+			for _, peer := range peers {
+				if peer != ownID {
+					p.waiting = append(p.waiting, peer)
+				}
+			}
+			envelopeTxCh <- p.envelope
+			if len(p.waiting) > 0 {
+				unacked = append(unacked, p)
+			}
+
+		case ack := <-ackRxCh:
+			if ack.To != ownID || ack.Session != session {
+				continue
+			}
+			for _, p := range unacked {
+				if p.envelope.Seq == ack.Seq {
+					p.waiting = slices.DeleteFunc(p.waiting, func(peer string) bool { return peer == ack.From })
+				}
+			}
+
+		case peers = <-peersCh:
+			for _, p := range unacked {
+				p.waiting = slices.DeleteFunc(p.waiting, func(peer string) bool { return !slices.Contains(peers, peer) })
+			}
+
+		case <-ticker.C:
+			for _, p := range unacked {
+				if len(p.waiting) > 0 {
+					envelopeTxCh <- p.envelope
+				}
+			}
+		}
+		unacked = slices.DeleteFunc(unacked, func(p *pending[T]) bool { return len(p.waiting) == 0 })
+	}
+}
+
+// Receiver acks every envelope from envelopeRxCh sent by other peers, and delivers each message once on msgCh
+func Receiver[T any](
+	ownID string,
+	envelopeRxCh <-chan Envelope[T],
+	ackTxCh chan<- Ack[T],
+	msgCh chan<- T,
+) {
+	type sender struct {
+		session int64
+		seen    map[uint64]bool
+		newest  uint64
+	}
+	senders := make(map[string]*sender)
+
+	for envelope := range envelopeRxCh {
+		if envelope.From == ownID {
+			continue
+		}
+		ackTxCh <- Ack[T]{From: ownID, To: envelope.From, Session: envelope.Session, Seq: envelope.Seq}
+
+		s, exists := senders[envelope.From]
+		if !exists || envelope.Session > s.session {
+			s = &sender{session: envelope.Session, seen: make(map[uint64]bool)}
+			senders[envelope.From] = s
+		}
+		if envelope.Session < s.session || s.seen[envelope.Seq] || envelope.Seq+window <= s.newest {
+			continue // Duplicate, or from an old session
+		}
+		s.seen[envelope.Seq] = true
+		if envelope.Seq > s.newest {
+			s.newest = envelope.Seq
+			for seq := range s.seen {
+				if seq+window <= s.newest {
+					delete(s.seen, seq)
+				}
+			}
+		}
+		msgCh <- envelope.Msg
+	}
+}
@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+
+	"multivator/lib/network/conn"
+)
+
+// A Transport delivers packets sent to a port to every node of the cluster, including the sender.
+//   - Broadcast only reaches nodes in the same broadcast domain
+//   - Multicast reaches nodes that joined the group, also across routers that forward multicast
+//   - Unicast sends a copy to each node in a static address list, and works wherever the nodes can reach each other
+type Transport interface {
+	// Listen returns a connection that receives the packets sent to port
+	Listen(port int) (net.PacketConn, error)
+	// Dial returns a sender for packets to port
+	Dial(port int) (Sender, error)
+}
+
+type Sender interface {
+	Send(packet []byte) error
+	Close() error
+}
+
+// Number of routers a multicast packet may pass
+const multicastTTL = 8
+
+type broadcast struct{}
+
+// Broadcast returns the transport sending to 255.255.255.255
+func Broadcast() Transport {
+	return broadcast{}
+}
+
+func (broadcast) Listen(port int) (net.PacketConn, error) {
+	return conn.DialBroadcastUDP(port), nil
+}
+
+func (broadcast) Dial(port int) (Sender, error) {
+	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	return &sender{conn: conn.DialBroadcastUDP(port), addrs: []net.Addr{addr}}, nil
+}
+
+type multicast struct {
+	group net.IP
+}
+
+// Multicast returns the transport sending to an IPv4 multicast group, such as 239.255.0.1
+func Multicast(group string) (Transport, error) {
+	ip := net.ParseIP(group).To4()
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("%q is not an IPv4 multicast address", group)
+	}
+	return multicast{group: ip}, nil
+}
+
+func (m multicast) Listen(port int) (net.PacketConn, error) {
+	return net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: m.group, Port: port})
+}
+
+func (m multicast) Dial(port int) (Sender, error) {
+	udpConn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetMulticastTTL(udpConn, multicastTTL); err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	return &sender{conn: udpConn, addrs: []net.Addr{&net.UDPAddr{IP: m.group, Port: port}}}, nil
+}
+
+type unicast struct {
+	hosts []string
+	own   int
+}
+
+// Unicast returns the transport sending to every host in a static list, indexed by node ID.
+//   - Listens on hosts[own], so several nodes can run on one machine with different loopback addresses
+func Unicast(hosts []string, own int) (Transport, error) {
+	if own < 0 || own >= len(hosts) {
+		return nil, fmt.Errorf("no unicast address for node %d in %v", own, hosts)
+	}
+	return unicast{hosts: hosts, own: own}, nil
+}
+
+func (u unicast) Listen(port int) (net.PacketConn, error) {
+	addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(u.hosts[u.own], fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp4", addr)
+}
+
+func (u unicast) Dial(port int) (Sender, error) {
+	s := &sender{}
+	for _, host := range u.hosts {
+		addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, fmt.Sprint(port)))
+		if err != nil {
+			return nil, err
+		}
+		s.addrs = append(s.addrs, addr)
+	}
+	localAddr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(u.hosts[u.own], "0"))
+	if err != nil {
+		return nil, err
+	}
+	if s.conn, err = net.ListenUDP("udp4", localAddr); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type sender struct {
+	conn  net.PacketConn
+	addrs []net.Addr
+}
+
+// Send writes the packet to every address, and returns the first error
+func (s *sender) Send(packet []byte) error {
+	var firstErr error
+	for _, addr := range s.addrs {
+		if _, err := s.conn.WriteTo(packet, addr); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *sender) Close() error {
+	return s.conn.Close()
+}
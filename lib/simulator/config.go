@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// simConfig holds the options from simulator.con and the command line.
+//   - Option names are the same as for SimElevatorServer.exe, and are not case sensitive
+type simConfig struct {
+	port                    int
+	numFloors               int
+	travelTimeBetweenFloors time.Duration
+	travelTimePassingFloor  time.Duration
+	btnDepressedTime        time.Duration
+	stopMotorOnDisconnect   bool
+	lightOff                rune
+	lightOn                 rune
+	keyStopButton           rune
+	keyObstruction          rune
+	keyMoveUp               rune
+	keyMoveStop             rune
+	keyMoveDown             rune
+	keyMoveInbounds         rune
+	// Indexed by button type. Up has no button at the top floor, down has none at the bottom floor
+	keyOrderButtons [numButtons][]rune
+}
+
+func defaultConfig() simConfig {
+	return simConfig{
+		port:                    15657,
+		numFloors:               4,
+		travelTimeBetweenFloors: 2 * time.Second,
+		travelTimePassingFloor:  500 * time.Millisecond,
+		btnDepressedTime:        200 * time.Millisecond,
+		stopMotorOnDisconnect:   true,
+		lightOff:                '-',
+		lightOn:                 '*',
+		keyStopButton:           'p',
+		keyObstruction:          '-',
+		keyMoveUp:               '9',
+		keyMoveStop:             '8',
+		keyMoveDown:             '7',
+		keyMoveInbounds:         '0',
+		keyOrderButtons: [numButtons][]rune{
+			[]rune("qwertyui?"),
+			[]rune("?sdfghjkl"),
+			[]rune("zxcvbnm,."),
+		},
+	}
+}
+
+// loadConfig reads the config file, then applies the command line arguments on top.
+//   - A missing or broken config file falls back to the previous settings, like SimElevatorServer.exe
+func loadConfig(args []string, fileName string, cfg simConfig) (simConfig, error) {
+	if contents, err := os.ReadFile(fileName); err != nil {
+		fmt.Printf("Encountered a problem when loading %s: %v\nUsing default settings...\n", fileName, err)
+	} else if fileCfg, err := parseConfig(tokenize(string(contents)), cfg); err != nil {
+		fmt.Printf("Encountered a problem when loading %s: %v\nUsing default settings...\n", fileName, err)
+	} else {
+		cfg = fileCfg
+	}
+
+	cfg, err := parseConfig(args, cfg)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.numFloors < 2 || cfg.numFloors > 9 {
+		return cfg, fmt.Errorf("numFloors must be between 2 and 9, got %d", cfg.numFloors)
+	}
+	return cfg, nil
+}
+
+// tokenize splits the config file into arguments, and removes // comments
+func tokenize(contents string) []string {
+	var tokens []string
+	for _, line := range strings.Split(contents, "\n") {
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	return tokens
+}
+
+// parseConfig applies --option value pairs to cfg. Unknown options are ignored.
+func parseConfig(args []string, cfg simConfig) (simConfig, error) {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(args[i], "--"), "=")
+		if !hasValue {
+			if i+1 >= len(args) {
+				return cfg, fmt.Errorf("missing value for --%s", name)
+			}
+			i++
+			value = args[i]
+		}
+		if err := cfg.set(strings.ToLower(name), value); err != nil {
+			return cfg, fmt.Errorf("--%s %s: %w", name, value, err)
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *simConfig) set(name, value string) error {
+	var err error
+	switch name {
+	case "port":
+		cfg.port, err = strconv.Atoi(value)
+	case "numfloors":
+		cfg.numFloors, err = strconv.Atoi(value)
+	case "traveltimebetweenfloors_ms":
+		cfg.travelTimeBetweenFloors, err = parseMillis(value)
+	case "traveltimepassingfloor_ms":
+		cfg.travelTimePassingFloor, err = parseMillis(value)
+	case "btndepressedtime_ms":
+		cfg.btnDepressedTime, err = parseMillis(value)
+	case "stopmotorondisconnect":
+		cfg.stopMotorOnDisconnect, err = strconv.ParseBool(value)
+	case "light_off":
+		cfg.lightOff, err = parseKey(value)
+	case "light_on":
+		cfg.lightOn, err = parseKey(value)
+	case "key_ordersup":
+		cfg.keyOrderButtons[btnHallUp] = []rune(value + "?")
+	case "key_ordersdown":
+		cfg.keyOrderButtons[btnHallDown] = []rune("?" + value)
+	case "key_orderscab":
+		cfg.keyOrderButtons[btnCab] = []rune(value)
+	case "key_stopbutton":
+		cfg.keyStopButton, err = parseKey(value)
+	case "key_obstruction":
+		cfg.keyObstruction, err = parseKey(value)
+	case "key_moveup":
+		cfg.keyMoveUp, err = parseKey(value)
+	case "key_movestop":
+		cfg.keyMoveStop, err = parseKey(value)
+	case "key_movedown":
+		cfg.keyMoveDown, err = parseKey(value)
+	case "key_moveinbounds":
+		cfg.keyMoveInbounds, err = parseKey(value)
+	}
+	return err
+}
+
+func parseMillis(value string) (time.Duration, error) {
+	ms, err := strconv.Atoi(value)
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+func parseKey(value string) (rune, error) {
+	keys := []rune(value)
+	if len(keys) != 1 {
+		return 0, fmt.Errorf("expected a single character")
+	}
+	return keys[0], nil
+}
@@ -0,0 +1,96 @@
+// Simulator is a Go replacement for SimElevatorServer.exe, using the same TCP protocol and options.
+//
+// Usage: go run . --port 17400 --numFloors 4
+//
+// Options are read from simulator.con in the working directory, and overridden by command line arguments.
+// Keys are read from stdin, and are handled when Enter is pressed. Uppercase letters toggle (hold) a button.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"unicode"
+)
+
+const configFileName = "simulator.con"
+
+func main() {
+	args := os.Args[1:]
+	cfg, err := loadConfig(args, configFileName, defaultConfig())
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.port))
+	if err != nil {
+		fmt.Println("Listen error:", err)
+		os.Exit(1)
+	}
+
+	state := newSimState(cfg)
+	// The port is not reloaded, since the listener is already open
+	reloadConfig := func() (simConfig, error) {
+		newCfg, err := loadConfig(args, configFileName, cfg)
+		newCfg.port = cfg.port
+		return newCfg, err
+	}
+
+	go serve(listener, state, reloadConfig)
+	go readKeys(state)
+
+	display := state.String()
+	fmt.Println(display)
+	for range state.updated {
+		newDisplay := state.String()
+		if newDisplay == display {
+			continue
+		}
+		// Move the cursor up to redraw the display in place
+		fmt.Printf("\033[%dA%s\n", strings.Count(display, "\n")+1, newDisplay)
+		display = newDisplay
+	}
+}
+
+// readKeys maps keys from stdin to panel inputs, using the key options from the config
+func readKeys(state *simState) {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		key, _, err := reader.ReadRune()
+		if err != nil {
+			return // No stdin, for example when running on CI
+		}
+
+		state.mtx.Lock()
+		cfg := state.cfg
+		state.mtx.Unlock()
+
+		lower := unicode.ToLower(key)
+		hold := unicode.IsUpper(key)
+		for btn, keys := range cfg.keyOrderButtons {
+			for floor, k := range keys {
+				if k == lower && k != '?' {
+					state.pressOrderButton(btn, floor, hold)
+				}
+			}
+		}
+
+		switch {
+		case lower == cfg.keyStopButton:
+			state.pressStopButton(hold)
+		case key == cfg.keyObstruction:
+			state.toggleObstruction()
+		case key == cfg.keyMoveUp:
+			state.setMotorDirection(dirUp)
+		case key == cfg.keyMoveStop:
+			state.setMotorDirection(dirStop)
+		case key == cfg.keyMoveDown:
+			state.setMotorDirection(dirDown)
+		case key == cfg.keyMoveInbounds:
+			state.moveWithinBounds()
+		}
+	}
+}
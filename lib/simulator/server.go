@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// serve accepts one client at a time and answers the 4-byte elevator server protocol.
+//   - Write instructions (0-5) have no reply
+//   - Read instructions (6-9) reply with 4 bytes, where the first byte echoes the instruction
+func serve(listener net.Listener, state *simState, reloadConfig func() (simConfig, error)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("Accept error:", err)
+			continue
+		}
+		state.setClientConnected(true)
+		handleClient(conn, state, reloadConfig)
+		conn.Close()
+		state.setClientConnected(false)
+	}
+}
+
+func handleClient(conn net.Conn, state *simState, reloadConfig func() (simConfig, error)) {
+	var buf [4]byte
+	for {
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			return
+		}
+
+		var reply []byte
+		switch buf[0] {
+		case 0:
+			cfg, err := reloadConfig()
+			if err != nil {
+				fmt.Println("Reload config failed:", err)
+				continue
+			}
+			state.reload(cfg)
+		case 1:
+			state.setMotorDirection(toDirn(buf[1]))
+		case 2:
+			state.setOrderButtonLight(int(buf[1]), int(buf[2]), buf[3] != 0)
+		case 3:
+			state.setFloorIndicator(int(buf[1]))
+		case 4:
+			state.setDoorLight(buf[1] != 0)
+		case 5:
+			state.setStopButtonLight(buf[1] != 0)
+		case 6:
+			reply = []byte{6, toByte(state.getOrderButton(int(buf[1]), int(buf[2]))), 0, 0}
+		case 7:
+			if floor := state.getFloor(); floor == -1 {
+				reply = []byte{7, 0, 0, 0}
+			} else {
+				reply = []byte{7, 1, byte(floor), 0}
+			}
+		case 8:
+			reply = []byte{8, toByte(state.getStopButton()), 0, 0}
+		case 9:
+			reply = []byte{9, toByte(state.getObstruction()), 0, 0}
+		}
+
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// toDirn decodes the motor direction byte, where -1 is sent as 255
+func toDirn(b byte) int {
+	switch {
+	case b == 0:
+		return dirStop
+	case b < 128:
+		return dirUp
+	default:
+		return dirDown
+	}
+}
+
+func toByte(a bool) byte {
+	if a {
+		return 1
+	}
+	return 0
+}
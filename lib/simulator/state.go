@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const numButtons = 3
+
+const (
+	btnHallUp = iota
+	btnHallDown
+	btnCab
+)
+
+const (
+	dirDown = -1
+	dirStop = 0
+	dirUp   = 1
+)
+
+// simState is the simulated elevator. All fields are protected by mtx.
+//   - Movement is driven by floor arrival and departure timers
+//   - updated is signalled on every change that should be printed
+type simState struct {
+	mtx sync.Mutex
+	cfg simConfig
+
+	orderButtons    [][numButtons]bool
+	orderLights     [][numButtons]bool
+	stopButton      bool
+	stopButtonLight bool
+	obstruction     bool
+	doorLight       bool
+	floorIndicator  int
+
+	currDirn   int
+	currFloor  int // 0..numFloors-1, or -1 when between floors
+	departDirn int // Only dirUp or dirDown
+	prevFloor  int // 0..numFloors-1, never -1
+
+	clientConnected bool
+	moveTimer       *time.Timer
+	moveGen         int // Invalidates movement timers that fired after they were stopped
+	updated         chan struct{}
+}
+
+func newSimState(cfg simConfig) *simState {
+	state := &simState{
+		cfg:     cfg,
+		updated: make(chan struct{}, 1),
+	}
+	state.reset()
+	return state
+}
+
+// reset is called on startup and on reload config. The elevator starts at a random position
+func (s *simState) reset() {
+	s.stopMoveTimer()
+	numFloors := s.cfg.numFloors
+	s.orderButtons = make([][numButtons]bool, numFloors)
+	s.orderLights = make([][numButtons]bool, numFloors)
+	s.stopButton = false
+	s.stopButtonLight = false
+	s.obstruction = false
+	s.doorLight = false
+	s.floorIndicator = 0
+
+	s.currDirn = dirStop
+	s.prevFloor = rand.Intn(numFloors)
+	s.currFloor = s.prevFloor
+	if rand.Intn(2) == 0 {
+		s.currFloor = -1
+	}
+	switch {
+	case s.currFloor == -1 && s.prevFloor == 0:
+		s.departDirn = dirUp
+	case s.currFloor == -1 && s.prevFloor == numFloors-1:
+		s.departDirn = dirDown
+	case rand.Intn(2) == 0:
+		s.departDirn = dirUp
+	default:
+		s.departDirn = dirDown
+	}
+}
+
+func (s *simState) isOutOfBounds() bool {
+	return s.currFloor == -1 && s.departDirn == dirDown && s.prevFloor == 0 ||
+		s.currFloor == -1 && s.departDirn == dirUp && s.prevFloor == s.cfg.numFloors-1
+}
+
+func (s *simState) notify() {
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Writing
+
+func (s *simState) setMotorDirection(dirn int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.setMotorDirectionLocked(dirn)
+	s.notify()
+}
+
+func (s *simState) setMotorDirectionLocked(dirn int) {
+	if s.currDirn == dirn || s.isOutOfBounds() {
+		return
+	}
+	s.currDirn = dirn
+	s.stopMoveTimer()
+	if dirn == dirStop {
+		return
+	}
+
+	switch {
+	case s.currFloor != -1:
+		// At a floor: depart this floor
+		s.departDirn = dirn
+		s.startMoveTimer(s.cfg.travelTimePassingFloor, s.floorDeparture)
+	case s.departDirn == dirn:
+		// Between floors: continue in that direction
+		s.startMoveTimer(s.cfg.travelTimeBetweenFloors, s.floorArrival)
+	default:
+		// Between floors: go back to previous floor
+		s.startMoveTimer(s.cfg.travelTimeBetweenFloors, s.floorArrivalPrev)
+	}
+}
+
+func (s *simState) setOrderButtonLight(btn, floor int, value bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.validButton(btn, floor) {
+		fmt.Printf("Tried to set order button light for button %d at floor %d\n", btn, floor)
+		return
+	}
+	s.orderLights[floor][btn] = value
+	s.notify()
+}
+
+func (s *simState) setFloorIndicator(floor int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if floor < 0 || floor >= s.cfg.numFloors {
+		fmt.Printf("Tried to set floor indicator to non-existent floor %d\n", floor)
+		return
+	}
+	s.floorIndicator = floor
+	s.notify()
+}
+
+func (s *simState) setDoorLight(value bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.doorLight = value
+	s.notify()
+}
+
+func (s *simState) setStopButtonLight(value bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stopButtonLight = value
+	s.notify()
+}
+
+// Reading
+
+func (s *simState) getOrderButton(btn, floor int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.validButton(btn, floor) ||
+		btn == btnHallUp && floor == s.cfg.numFloors-1 ||
+		btn == btnHallDown && floor == 0 {
+		return false
+	}
+	return s.orderButtons[floor][btn]
+}
+
+func (s *simState) getFloor() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.currFloor
+}
+
+func (s *simState) getStopButton() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.stopButton
+}
+
+func (s *simState) getObstruction() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.obstruction
+}
+
+func (s *simState) validButton(btn, floor int) bool {
+	return btn >= 0 && btn < numButtons && floor >= 0 && floor < s.cfg.numFloors
+}
+
+// Panel inputs
+
+// pressOrderButton holds the button down for btnDepressedTime, or toggles it if hold is true
+func (s *simState) pressOrderButton(btn, floor int, hold bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.validButton(btn, floor) {
+		return
+	}
+	if hold {
+		s.orderButtons[floor][btn] = !s.orderButtons[floor][btn]
+	} else {
+		s.orderButtons[floor][btn] = true
+		time.AfterFunc(s.cfg.btnDepressedTime, func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			if floor < len(s.orderButtons) {
+				s.orderButtons[floor][btn] = false
+			}
+			s.notify()
+		})
+	}
+	s.notify()
+}
+
+func (s *simState) pressStopButton(hold bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if hold {
+		s.stopButton = !s.stopButton
+	} else {
+		s.stopButton = true
+		time.AfterFunc(s.cfg.btnDepressedTime, func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+			s.stopButton = false
+			s.notify()
+		})
+	}
+	s.notify()
+}
+
+func (s *simState) toggleObstruction() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.obstruction = !s.obstruction
+	s.notify()
+}
+
+func (s *simState) moveWithinBounds() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.isOutOfBounds() {
+		s.currFloor = s.prevFloor
+		s.currDirn = dirStop
+	}
+	s.notify()
+}
+
+func (s *simState) setClientConnected(connected bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.clientConnected = connected
+	if s.cfg.stopMotorOnDisconnect && !connected {
+		s.setMotorDirectionLocked(dirStop)
+	}
+	s.notify()
+}
+
+func (s *simState) reload(cfg simConfig) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.cfg = cfg
+	s.reset()
+	s.clientConnected = true
+	s.notify()
+}
+
+// Movement
+
+// startMoveTimer schedules the next movement event. Must be called with mtx held
+func (s *simState) startMoveTimer(duration time.Duration, event func()) {
+	gen := s.moveGen
+	s.moveTimer = time.AfterFunc(duration, func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		if gen != s.moveGen {
+			return
+		}
+		event()
+		s.notify()
+	})
+}
+
+// stopMoveTimer cancels the pending movement event. Must be called with mtx held
+func (s *simState) stopMoveTimer() {
+	s.moveGen++
+	if s.moveTimer != nil {
+		s.moveTimer.Stop()
+		s.moveTimer = nil
+	}
+}
+
+func (s *simState) floorArrival() {
+	s.arriveAt(s.prevFloor + s.currDirn)
+}
+
+func (s *simState) floorArrivalPrev() {
+	s.arriveAt(s.prevFloor)
+}
+
+func (s *simState) arriveAt(floor int) {
+	s.currFloor = floor
+	s.prevFloor = floor
+	s.startMoveTimer(s.cfg.travelTimePassingFloor, s.floorDeparture)
+}
+
+func (s *simState) floorDeparture() {
+	switch {
+	case s.currDirn == dirDown && s.currFloor <= 0:
+		fmt.Printf("Elevator departed the bottom floor going downward! "+
+			"Press [%c] to move the elevator within bounds...\n", s.cfg.keyMoveInbounds)
+	case s.currDirn == dirUp && s.currFloor >= s.cfg.numFloors-1:
+		fmt.Printf("Elevator departed the top floor going upward! "+
+			"Press [%c] to move the elevator within bounds...\n", s.cfg.keyMoveInbounds)
+	default:
+		s.startMoveTimer(s.cfg.travelTimeBetweenFloors, s.floorArrival)
+	}
+	s.currFloor = -1
+	s.departDirn = s.currDirn
+}
+
+// String draws the same ascii display as SimElevatorServer.exe
+func (s *simState) String() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	numFloors := s.cfg.numFloors
+	light := func(on bool) rune {
+		if on {
+			return s.cfg.lightOn
+		}
+		return s.cfg.lightOff
+	}
+	blank := strings.Repeat(" ", numFloors*4+1)
+	dashes := strings.Repeat("-", numFloors*4+1)
+
+	floors := make([]string, numFloors)
+	for f := range floors {
+		floors[f] = fmt.Sprint(f)
+	}
+	lines := [][]rune{
+		[]rune("+-----------+" + dashes + "+            "),
+		[]rune("|           |" + blank + "|            "),
+		[]rune("| Floor     |  " + strings.Join(floors, "   ") + "  |            "),
+		[]rune("+-----------+" + dashes + "+-----------+"),
+		[]rune("| Hall Up   |" + blank + "| Door:     |"),
+		[]rune("| Hall Down |" + blank + "| Stop:     |"),
+		[]rune("| Cab       |" + blank + "| Obstr:    |"),
+		[]rune("+-----------+" + dashes + "+-----------+"),
+	}
+	end := len(lines[4]) - 3
+
+	lines[2][16+s.floorIndicator*4] = s.cfg.lightOn
+	lines[4][end] = light(s.doorLight)
+	lines[5][end] = light(s.stopButtonLight)
+	lines[6][end] = '^'
+	if s.obstruction {
+		lines[6][end] = 'v'
+	}
+
+	for floor, lights := range s.orderLights {
+		for btn, on := range lights {
+			if btn == btnHallUp && floor == numFloors-1 || btn == btnHallDown && floor == 0 {
+				continue
+			}
+			lines[4+btn][15+floor*4] = light(on)
+		}
+	}
+
+	var pos int
+	switch {
+	case s.currFloor != -1:
+		pos = 15 + s.currFloor*4
+	case s.departDirn == dirUp:
+		pos = 17 + s.prevFloor*4
+	default:
+		pos = 13 + s.prevFloor*4
+	}
+	lines[1][pos] = '#'
+	switch s.currDirn {
+	case dirUp:
+		lines[1][pos+1] = '>'
+	case dirDown:
+		lines[1][pos-1] = '<'
+	}
+
+	connected := "Disconnected"
+	if s.clientConnected {
+		connected = "Connected   "
+	}
+	copy(lines[2][len(lines[2])-12:], []rune(connected))
+
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = string(line)
+	}
+	return strings.Join(out, "\n")
+}
@@ -4,21 +4,61 @@ import (
 	"time"
 )
 
+// Set on startup from command line flags
 var NodeID int
 
+// Software version sent to peers. Set at build time with -ldflags "-X multivator/src/config.Version=v1.2.3"
+var Version = "dev"
+
+// Node IDs must fit in the bits that dispatcher.Timestamp reserves for them
+const MaxElevators = 1 << 8
+
+// Defaults, which can be overridden on startup by a config file and command line flags. See load.go
+var (
+	MsgInterval       = 10 * time.Millisecond
+	BidTimeout        = 1 * time.Second
+	NumElevators      = 3
+	NumFloors         = 4
+	StuckTimeout      = 4 * time.Second
+	SensorPollRate    = 25 * time.Millisecond
+	DoorOpenDuration  = 3 * time.Second
+	TravelDuration    = 2 * time.Second
+	BcastPort         = 16400
+	PeersPort         = 17400
+	CostFunction      = "time"          // See dispatcher.NewCostFunction for the available cost functions
+	AssignmentMode    = "bid"           // "bid" or "central"
+	RebalanceInterval = 5 * time.Second // Period for re-auctioning assigned hall orders in bid mode. 0 disables it
+	ReassignThreshold = 2 * time.Second // Minimum improvement before an assigned hall order is moved
+	StateDir          = "state"         // Directory for the persisted cab orders of each node. Empty disables persistence
+	WireEncoding      = "binary"        // "binary" once every peer decodes it, or always "json". Both are received, see bcast.Encoding
+	ClusterKey        = ""              // Pre-shared key for signing all network messages. Empty disables authentication
+	Cluster           = ""              // Namespace of the cluster. Nodes ignore other clusters on the same network and ports
+	Transport         = "broadcast"     // "broadcast", "multicast" or "unicast". See transport.Transport
+	MulticastGroup    = "239.255.64.1"  // IPv4 group for the multicast transport
+	UnicastHosts      []string          // Host of every node, indexed by node ID, for the unicast transport
+
+	// Peer failure detector, see peers.Detector
+	HeartbeatInterval        = 15 * time.Millisecond
+	SuspectPhi               = 3.0
+	LostPhi                  = 8.0
+	HeartbeatMinDeviation    = 50 * time.Millisecond
+	HeartbeatAcceptablePause = 300 * time.Millisecond
+
+	// Time a starting node listens for other nodes with its id, see peers.Claim
+	IDClaimDuration = 500 * time.Millisecond
+)
+
+// Allowed values of the string options, checked by Config.Validate
+var (
+	CostFunctions   = []string{"time", "nearest", "load", "destination"} // Must match dispatcher.NewCostFunction
+	AssignmentModes = []string{"bid", "central"}
+	WireEncodings   = []string{"json", "binary"}
+	Transports      = []string{"broadcast", "multicast", "unicast"}
+)
+
 const (
-	MsgRepetitions   = 5
-	MsgInterval      = 10 * time.Millisecond
-	BidTimeout       = 1 * time.Second
-	NumElevators     = 3
-	NumFloors        = 4
 	NumButtons       = 3
-	StuckTimeout     = 4 * time.Second
 	BtnPressInterval = 75 * time.Millisecond
-	SensorPollRate   = 25 * time.Millisecond
-	DoorOpenDuration = 3 * time.Second
-	TravelDuration   = 2 * time.Second
 	DirChangePenalty = 2 * time.Second
-	BcastPort        = 16400
-	PeersPort        = 17400
+	StateInterval    = 250 * time.Millisecond // State broadcast and reassignment period in central mode
 )
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config mirrors the configurable variables in config.go.
+//   - Durations are written as strings in the config file, for example "3s" or "10ms".
+type Config struct {
+	MsgInterval       Duration `json:"msgInterval"`
+	BidTimeout        Duration `json:"bidTimeout"`
+	NumElevators      int      `json:"numElevators"`
+	NumFloors         int      `json:"numFloors"`
+	StuckTimeout      Duration `json:"stuckTimeout"`
+	SensorPollRate    Duration `json:"sensorPollRate"`
+	DoorOpenDuration  Duration `json:"doorOpenDuration"`
+	TravelDuration    Duration `json:"travelDuration"`
+	BcastPort         int      `json:"bcastPort"`
+	PeersPort         int      `json:"peersPort"`
+	CostFunction      string   `json:"costFunction"`
+	AssignmentMode    string   `json:"assignmentMode"`
+	RebalanceInterval Duration `json:"rebalanceInterval"`
+	ReassignThreshold Duration `json:"reassignThreshold"`
+	StateDir          string   `json:"stateDir"`
+	WireEncoding      string   `json:"wireEncoding"`
+	ClusterKey        string   `json:"clusterKey"`
+	Cluster           string   `json:"cluster"`
+	Transport         string   `json:"transport"`
+	MulticastGroup    string   `json:"multicastGroup"`
+	UnicastHosts      HostList `json:"unicastHosts"`
+
+	HeartbeatInterval        Duration `json:"heartbeatInterval"`
+	SuspectPhi               float64  `json:"suspectPhi"`
+	LostPhi                  float64  `json:"lostPhi"`
+	HeartbeatMinDeviation    Duration `json:"heartbeatMinDeviation"`
+	HeartbeatAcceptablePause Duration `json:"heartbeatAcceptablePause"`
+
+	IDClaimDuration Duration `json:"idClaimDuration"`
+}
+
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"3s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// HostList is written as a JSON array in the config file, and comma separated on the command line
+type HostList []string
+
+func (hosts *HostList) String() string {
+	return strings.Join(*hosts, ",")
+}
+
+func (hosts *HostList) Set(value string) error {
+	*hosts = nil
+	if value != "" {
+		*hosts = strings.Split(value, ",")
+	}
+	return nil
+}
+
+// loaded holds the values bound to command line flags, and later the merged configuration
+var loaded = current()
+
+// RegisterFlags adds a command line flag for each configurable variable.
+//   - Must be called before fs.Parse, and followed by Load.
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.DurationVar((*time.Duration)(&loaded.MsgInterval), "msg-interval", time.Duration(loaded.MsgInterval), "Interval between retransmissions of unacknowledged bids and syncs")
+	fs.DurationVar((*time.Duration)(&loaded.BidTimeout), "bid-timeout", time.Duration(loaded.BidTimeout), "Time to wait for all bids before taking the order")
+	fs.IntVar(&loaded.NumElevators, "elevators", loaded.NumElevators, "Number of elevators in the building")
+	fs.IntVar(&loaded.NumFloors, "floors", loaded.NumFloors, "Number of floors in the building")
+	fs.DurationVar((*time.Duration)(&loaded.StuckTimeout), "stuck-timeout", time.Duration(loaded.StuckTimeout), "Time between floors before the elevator is considered stuck")
+	fs.DurationVar((*time.Duration)(&loaded.SensorPollRate), "poll-rate", time.Duration(loaded.SensorPollRate), "Polling interval for buttons and sensors")
+	fs.DurationVar((*time.Duration)(&loaded.DoorOpenDuration), "door-duration", time.Duration(loaded.DoorOpenDuration), "Time the door stays open")
+	fs.DurationVar((*time.Duration)(&loaded.TravelDuration), "travel-duration", time.Duration(loaded.TravelDuration), "Estimated travel time between two floors")
+	fs.IntVar(&loaded.BcastPort, "bcast-port", loaded.BcastPort, "UDP port for bids and syncs")
+	fs.IntVar(&loaded.PeersPort, "peers-port", loaded.PeersPort, "UDP port for peer heartbeats. The elevator server port is peers-port + id")
+	fs.StringVar(&loaded.CostFunction, "cost-function", loaded.CostFunction, "Cost function for hall order bids: time, nearest, load or destination")
+	fs.StringVar(&loaded.AssignmentMode, "assignment-mode", loaded.AssignmentMode, "Hall order assignment: bid (one order at a time) or central (coordinator assigns all orders)")
+	fs.DurationVar((*time.Duration)(&loaded.RebalanceInterval), "rebalance-interval", time.Duration(loaded.RebalanceInterval), "Period for re-auctioning assigned hall orders in bid mode, 0 disables it")
+	fs.DurationVar((*time.Duration)(&loaded.ReassignThreshold), "reassign-threshold", time.Duration(loaded.ReassignThreshold), "Minimum cost improvement before an assigned hall order is moved")
+	fs.StringVar(&loaded.StateDir, "state-dir", loaded.StateDir, "Directory where cab orders are saved for crash recovery, empty disables it")
+	fs.StringVar(&loaded.WireEncoding, "wire-encoding", loaded.WireEncoding, "Preferred encoding of sent bids and syncs: binary once every peer decodes it, or always json. Both are received")
+	fs.StringVar(&loaded.ClusterKey, "cluster-key", loaded.ClusterKey, "Pre-shared key for signing network messages, shared by all nodes. Empty disables authentication")
+	fs.StringVar(&loaded.Cluster, "cluster", loaded.Cluster, "Cluster name. Nodes only see peers and messages of the same cluster, so several clusters can share a network")
+	fs.StringVar(&loaded.Transport, "transport", loaded.Transport, "Transport for peers and messages: broadcast (one subnet), multicast or unicast (routed networks)")
+	fs.StringVar(&loaded.MulticastGroup, "multicast-group", loaded.MulticastGroup, "IPv4 multicast group for the multicast transport")
+	fs.Var(&loaded.UnicastHosts, "unicast-hosts", "Comma separated host of every node, indexed by id, for the unicast transport")
+	fs.DurationVar((*time.Duration)(&loaded.HeartbeatInterval), "heartbeat-interval", time.Duration(loaded.HeartbeatInterval), "Interval between peer heartbeats")
+	fs.Float64Var(&loaded.SuspectPhi, "suspect-phi", loaded.SuspectPhi, "Suspicion level at which a peer is suspected")
+	fs.Float64Var(&loaded.LostPhi, "lost-phi", loaded.LostPhi, "Suspicion level at which a peer is lost, and its hall orders are taken over")
+	fs.DurationVar((*time.Duration)(&loaded.HeartbeatMinDeviation), "heartbeat-min-deviation", time.Duration(loaded.HeartbeatMinDeviation), "Lower bound of the heartbeat inter-arrival deviation in the failure detector")
+	fs.DurationVar((*time.Duration)(&loaded.HeartbeatAcceptablePause), "heartbeat-acceptable-pause", time.Duration(loaded.HeartbeatAcceptablePause), "Heartbeat pause tolerated by the failure detector before suspicion rises")
+	fs.DurationVar((*time.Duration)(&loaded.IDClaimDuration), "id-claim-duration", time.Duration(loaded.IDClaimDuration), "Time to listen for other nodes with the same id on startup, before joining")
+}
+
+// Load is called on startup after the command line flags are parsed.
+//   - Reads the config file at path if it is not empty
+//   - Flags that were set on the command line take precedence over the file
+//   - Validates the result and applies it to the variables in config.go
+func Load(fs *flag.FlagSet, path string) error {
+	setFlags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+
+	if path != "" {
+		if err := loadFile(path, &loaded); err != nil {
+			return err
+		}
+		for name, value := range setFlags {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("flag --%s: %w", name, err)
+			}
+		}
+	}
+
+	if err := loaded.Validate(NodeID); err != nil {
+		return err
+	}
+	loaded.apply()
+	return nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate returns all configuration errors joined together, or nil if the configuration is usable
+func (cfg Config) Validate(nodeID int) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	checkOneOf := func(name, value string, allowed []string) {
+		check(slices.Contains(allowed, value), "%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+	}
+
+	check(cfg.NumElevators >= 1 && cfg.NumElevators <= MaxElevators,
+		"numElevators must be between 1 and %d, got %d", MaxElevators, cfg.NumElevators)
+	check(cfg.NumFloors >= 2, "numFloors must be at least 2, got %d", cfg.NumFloors)
+	check(nodeID >= 0 && nodeID < cfg.NumElevators,
+		"id must be between 0 and numElevators-1 (%d), got %d", cfg.NumElevators-1, nodeID)
+
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"msgInterval", cfg.MsgInterval},
+		{"bidTimeout", cfg.BidTimeout},
+		{"stuckTimeout", cfg.StuckTimeout},
+		{"sensorPollRate", cfg.SensorPollRate},
+		{"doorOpenDuration", cfg.DoorOpenDuration},
+		{"travelDuration", cfg.TravelDuration},
+		{"heartbeatInterval", cfg.HeartbeatInterval},
+		{"heartbeatMinDeviation", cfg.HeartbeatMinDeviation},
+	}
+	for _, d := range durations {
+		check(d.value > 0, "%s must be positive, got %s", d.name, time.Duration(d.value))
+	}
+
+	check(cfg.BidTimeout > cfg.MsgInterval,
+		"bidTimeout (%s) must be longer than msgInterval (%s)", time.Duration(cfg.BidTimeout), time.Duration(cfg.MsgInterval))
+	check(time.Duration(cfg.StuckTimeout) > time.Duration(cfg.TravelDuration),
+		"stuckTimeout (%s) must be longer than travelDuration (%s)", time.Duration(cfg.StuckTimeout), time.Duration(cfg.TravelDuration))
+
+	check(cfg.BcastPort > 0 && cfg.BcastPort <= 65535, "bcastPort must be between 1 and 65535, got %d", cfg.BcastPort)
+	check(cfg.PeersPort > 0 && cfg.PeersPort+cfg.NumElevators-1 <= 65535,
+		"peersPort must be between 1 and %d, got %d", 65535-cfg.NumElevators+1, cfg.PeersPort)
+	checkOneOf("costFunction", cfg.CostFunction, CostFunctions)
+	checkOneOf("assignmentMode", cfg.AssignmentMode, AssignmentModes)
+	check(cfg.RebalanceInterval >= 0, "rebalanceInterval must not be negative, got %s", time.Duration(cfg.RebalanceInterval))
+	check(cfg.RebalanceInterval == 0 || time.Duration(cfg.RebalanceInterval) > time.Duration(cfg.BidTimeout),
+		"rebalanceInterval (%s) must be 0 or longer than bidTimeout (%s)", time.Duration(cfg.RebalanceInterval), time.Duration(cfg.BidTimeout))
+	check(cfg.ReassignThreshold >= 0, "reassignThreshold must not be negative, got %s", time.Duration(cfg.ReassignThreshold))
+	checkOneOf("wireEncoding", cfg.WireEncoding, WireEncodings)
+	check(cfg.ClusterKey == "" || len(cfg.ClusterKey) >= 16,
+		"clusterKey must be empty or at least 16 characters, got %d", len(cfg.ClusterKey))
+	check(len(cfg.Cluster) <= 255, "cluster must be at most 255 bytes, got %d", len(cfg.Cluster))
+	checkOneOf("transport", cfg.Transport, Transports)
+	if cfg.Transport == "multicast" {
+		group := net.ParseIP(cfg.MulticastGroup).To4()
+		check(group != nil && group.IsMulticast(), "multicastGroup must be an IPv4 multicast address, got %q", cfg.MulticastGroup)
+	}
+	if cfg.Transport == "unicast" {
+		check(len(cfg.UnicastHosts) == cfg.NumElevators,
+			"unicastHosts must have one host for each of the %d elevators, got %d", cfg.NumElevators, len(cfg.UnicastHosts))
+	}
+	check(cfg.IDClaimDuration > cfg.HeartbeatInterval,
+		"idClaimDuration (%s) must be longer than heartbeatInterval (%s)", time.Duration(cfg.IDClaimDuration), time.Duration(cfg.HeartbeatInterval))
+	check(cfg.SuspectPhi > 0 && cfg.SuspectPhi < cfg.LostPhi,
+		"suspectPhi (%g) must be positive and lower than lostPhi (%g)", cfg.SuspectPhi, cfg.LostPhi)
+	check(cfg.HeartbeatAcceptablePause >= 0, "heartbeatAcceptablePause must not be negative, got %s", time.Duration(cfg.HeartbeatAcceptablePause))
+	check(cfg.BcastPort != cfg.PeersPort, "bcastPort and peersPort must be different, both are %d", cfg.BcastPort)
+
+	return errors.Join(errs...)
+}
+
+func current() Config {
+	return Config{
+		MsgInterval:       Duration(MsgInterval),
+		BidTimeout:        Duration(BidTimeout),
+		NumElevators:      NumElevators,
+		NumFloors:         NumFloors,
+		StuckTimeout:      Duration(StuckTimeout),
+		SensorPollRate:    Duration(SensorPollRate),
+		DoorOpenDuration:  Duration(DoorOpenDuration),
+		TravelDuration:    Duration(TravelDuration),
+		BcastPort:         BcastPort,
+		PeersPort:         PeersPort,
+		CostFunction:      CostFunction,
+		AssignmentMode:    AssignmentMode,
+		RebalanceInterval: Duration(RebalanceInterval),
+		ReassignThreshold: Duration(ReassignThreshold),
+		StateDir:          StateDir,
+		WireEncoding:      WireEncoding,
+		ClusterKey:        ClusterKey,
+		Cluster:           Cluster,
+		Transport:         Transport,
+		MulticastGroup:    MulticastGroup,
+		UnicastHosts:      slices.Clone(UnicastHosts),
+
+		HeartbeatInterval:        Duration(HeartbeatInterval),
+		SuspectPhi:               SuspectPhi,
+		LostPhi:                  LostPhi,
+		HeartbeatMinDeviation:    Duration(HeartbeatMinDeviation),
+		HeartbeatAcceptablePause: Duration(HeartbeatAcceptablePause),
+
+		IDClaimDuration: Duration(IDClaimDuration),
+	}
+}
+
+func (cfg Config) apply() {
+	MsgInterval = time.Duration(cfg.MsgInterval)
+	BidTimeout = time.Duration(cfg.BidTimeout)
+	NumElevators = cfg.NumElevators
+	NumFloors = cfg.NumFloors
+	StuckTimeout = time.Duration(cfg.StuckTimeout)
+	SensorPollRate = time.Duration(cfg.SensorPollRate)
+	DoorOpenDuration = time.Duration(cfg.DoorOpenDuration)
+	TravelDuration = time.Duration(cfg.TravelDuration)
+	BcastPort = cfg.BcastPort
+	PeersPort = cfg.PeersPort
+	CostFunction = cfg.CostFunction
+	AssignmentMode = cfg.AssignmentMode
+	RebalanceInterval = time.Duration(cfg.RebalanceInterval)
+	ReassignThreshold = time.Duration(cfg.ReassignThreshold)
+	StateDir = cfg.StateDir
+	WireEncoding = cfg.WireEncoding
+	ClusterKey = cfg.ClusterKey
+	Cluster = cfg.Cluster
+	Transport = cfg.Transport
+	MulticastGroup = cfg.MulticastGroup
+	UnicastHosts = cfg.UnicastHosts
+
+	HeartbeatInterval = time.Duration(cfg.HeartbeatInterval)
+	SuspectPhi = cfg.SuspectPhi
+	LostPhi = cfg.LostPhi
+	HeartbeatMinDeviation = time.Duration(cfg.HeartbeatMinDeviation)
+	HeartbeatAcceptablePause = time.Duration(cfg.HeartbeatAcceptablePause)
+
+	IDClaimDuration = time.Duration(cfg.IDClaimDuration)
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	if err := current().Validate(0); err != nil {
+		t.Errorf("default configuration is invalid: %v", err)
+	}
+}
+
+func TestValidateReportsEveryEnum(t *testing.T) {
+	cfg := current()
+	cfg.CostFunction = "fastest"
+	cfg.AssignmentMode = "random"
+	cfg.WireEncoding = "xml"
+	cfg.Transport = "pigeon"
+	cfg.NumFloors = 1
+
+	err := cfg.Validate(0)
+	if err == nil {
+		t.Fatal("invalid configuration was accepted")
+	}
+	for _, name := range []string{"costFunction", "assignmentMode", "wireEncoding", "transport", "numFloors"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error does not mention %s: %v", name, err)
+		}
+	}
+}
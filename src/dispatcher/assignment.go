@@ -0,0 +1,146 @@
+package dispatcher
+
+import (
+	"slices"
+	"time"
+
+	"multivator/lib/network/peers"
+	"multivator/src/config"
+	"multivator/src/types"
+	"multivator/src/utils"
+)
+
+// Central assignment mode: all nodes broadcast their state periodically, and the coordinator
+// assigns every outstanding hall order. The assignment only depends on the shared states,
+// so any node computes the same result when it becomes coordinator.
+
+// liveNodes returns the sorted IDs of connected peers we have received a state from.
+//   - If we are not connected, we are alone in our partition
+func liveNodes(peerList peers.PeerUpdate, stateMap map[int]types.ElevState) []int {
+	if !slices.Contains(peerList.Peers, peers.NodeID(config.NodeID)) {
+		return []int{config.NodeID}
+	}
+	nodes := make([]int, 0, len(peerList.Peers))
+	for _, peer := range peerList.Peers {
+		if !inBuilding(peer) {
+			continue
+		}
+		if _, exists := stateMap[int(peer)]; exists {
+			nodes = append(nodes, int(peer))
+		}
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// isCoordinator returns true if we have the lowest live node ID
+func isCoordinator(nodes []int) bool {
+	return len(nodes) > 0 && nodes[0] == config.NodeID
+}
+
+// AssignHallOrders computes a global assignment of all hall orders in orders to the given nodes.
+//   - Cab orders are kept as they are
+//   - Greedy: repeatedly assigns the order with the lowest cost on any node, given the orders already assigned to it
+//   - An order only moves away from a live assignee if another node is faster by more than config.ReassignThreshold
+//   - Remaining ties are broken by floor, button and node ID, so the result is deterministic
+func AssignHallOrders(costFn CostFunction, orders types.Orders, stateMap map[int]types.ElevState, nodes []int) types.Orders {
+	if len(nodes) == 0 {
+		return orders.Clone()
+	}
+
+	assigned := orders.Clone()
+	var remaining []types.HallOrder
+	currentAssignee := make(map[types.HallOrder]int)
+	for floor := range config.NumFloors {
+		for btn := range types.BT_Cab {
+			order := types.HallOrder{Floor: floor, Button: types.HallType(btn)}
+			currentAssignee[order] = -1
+			for node := range assigned {
+				if assigned[node][floor][btn] {
+					if currentAssignee[order] == -1 && slices.Contains(nodes, node) {
+						currentAssignee[order] = node
+					}
+					if !slices.Contains(remaining, order) {
+						remaining = append(remaining, order)
+					}
+				}
+				assigned[node][floor][btn] = false
+			}
+		}
+	}
+
+	views := make(map[int]types.ElevState, len(nodes))
+	for _, node := range nodes {
+		views[node] = localView(stateMap[node], node)
+	}
+
+	// effectiveCost adds the threshold to every node except the current assignee
+	effectiveCost := func(node int, order types.HallOrder) time.Duration {
+		cost := costFn.Cost(views[node], order)
+		if node != currentAssignee[order] {
+			cost += config.ReassignThreshold
+		}
+		return cost
+	}
+
+	for len(remaining) > 0 {
+		bestOrder, bestNode := 0, nodes[0]
+		bestCost := effectiveCost(bestNode, remaining[bestOrder])
+		for i, order := range remaining {
+			for _, node := range nodes {
+				if cost := effectiveCost(node, order); cost < bestCost {
+					bestOrder, bestNode, bestCost = i, node, cost
+				}
+			}
+		}
+
+		order := remaining[bestOrder]
+		assigned[bestNode][order.Floor][order.Button] = true
+		views[bestNode].Orders[config.NodeID][order.Floor][order.Button] = true
+		remaining = slices.Delete(remaining, bestOrder, bestOrder+1)
+	}
+	return assigned
+}
+
+// localView moves the cab orders of node into our own row, since the cost functions and
+// executor helpers only look at the orders of config.NodeID
+func localView(state types.ElevState, node int) types.ElevState {
+	view := state
+	view.Orders = types.NewOrders(config.NumElevators, config.NumFloors)
+	for floor := range config.NumFloors {
+		view.Orders[config.NodeID][floor][types.BT_Cab] = state.Orders[node][floor][types.BT_Cab]
+	}
+	return view
+}
+
+// hallOrdersEqual returns true if both order matrices have the same hall orders
+func hallOrdersEqual(a, b types.Orders) bool {
+	equal := true
+	utils.ForEachOrder(a, func(node, floor, btn int) {
+		if types.ButtonType(btn) != types.BT_Cab && a[node][floor][btn] != b[node][floor][btn] {
+			equal = false
+		}
+	})
+	return equal
+}
+
+// reassignHallOrders is called by the coordinator on every state tick.
+//   - New hall orders are confirmed in a bid round first, and picked up from the row of the node that announced them
+//   - Applies and broadcasts the assignment if it differs from the current hall orders
+func reassignHallOrders(
+	costFn CostFunction,
+	elevator *types.ElevState,
+	versions *orderVersions,
+	stateMap map[int]types.ElevState,
+	nodes []int,
+	orderUpdateCh chan<- types.Orders,
+	syncTxBufCh chan<- Msg[Sync],
+) {
+	assigned := AssignHallOrders(costFn, elevator.Orders, stateMap, nodes)
+	if hallOrdersEqual(assigned, elevator.Orders) {
+		return
+	}
+	elevator.Orders = assigned
+	orderUpdateCh <- elevator.Orders.Clone()
+	syncTxBufCh <- versions.sync(SyncAssignment, elevator.Orders)
+}
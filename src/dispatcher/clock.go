@@ -0,0 +1,206 @@
+package dispatcher
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"multivator/src/config"
+)
+
+// Timestamp is a hybrid logical clock timestamp packed into 64 bits: wall clock milliseconds,
+// a logical counter for events within the same millisecond, and the node ID in the lowest bits.
+// Timestamps from different nodes are never equal, so any two changes of an order cell are ordered.
+// The zero value means the cell has never changed.
+type Timestamp uint64
+
+const (
+	logicalBits = 8
+	nodeBits    = 8
+	maxLogical  = 1<<logicalBits - 1
+	maxNode     = 1<<nodeBits - 1
+)
+
+func newTimestamp(wall, logical uint64) Timestamp {
+	return packTimestamp(wall, logical, uint64(config.NodeID))
+}
+
+func packTimestamp(wall, logical, node uint64) Timestamp {
+	return Timestamp(wall<<(logicalBits+nodeBits) | logical<<nodeBits | node&maxNode)
+}
+
+// successor returns the next timestamp of the node that made ts, and is the same on every node.
+//   - Increments the logical counter, carrying into the wall clock, and keeps the node bits
+func (ts Timestamp) successor() Timestamp {
+	wall, logical := ts.wall(), ts.logical()+1
+	if logical > maxLogical {
+		wall, logical = wall+1, 0
+	}
+	return packTimestamp(wall, logical, uint64(ts)&maxNode)
+}
+
+func (ts Timestamp) wall() uint64 {
+	return uint64(ts) >> (logicalBits + nodeBits)
+}
+
+func (ts Timestamp) logical() uint64 {
+	return uint64(ts) >> nodeBits & maxLogical
+}
+
+// hlc is a hybrid logical clock.
+//   - Follows the wall clock, but never goes backwards
+//   - Moves past every received timestamp, so a change made after receiving another change is newer
+//   - Survives restarts without persisted state, as long as the wall clocks are roughly synchronized
+type hlc struct {
+	last Timestamp
+}
+
+func (clock *hlc) now() Timestamp {
+	wall := uint64(time.Now().UnixMilli())
+	lastWall, logical := clock.last.wall(), clock.last.logical()
+	switch {
+	case wall > lastWall:
+		logical = 0
+	case logical < maxLogical:
+		wall, logical = lastWall, logical+1
+	default:
+		wall, logical = lastWall+1, 0
+	}
+	clock.last = newTimestamp(wall, logical)
+	return clock.last
+}
+
+// update is called with the newest timestamp of every received sync
+func (clock *hlc) update(received Timestamp) {
+	if received > clock.last {
+		clock.last = newTimestamp(received.wall(), received.logical())
+	}
+}
+
+// CellClocks holds the timestamp of the last change of every order cell, indexed [node][floor][btn]
+type CellClocks [][][config.NumButtons]Timestamp
+
+func NewCellClocks(numElevators, numFloors int) CellClocks {
+	clocks := make(CellClocks, numElevators)
+	for node := range clocks {
+		clocks[node] = make([][config.NumButtons]Timestamp, numFloors)
+	}
+	return clocks
+}
+
+func (clocks CellClocks) Clone() CellClocks {
+	clone := make(CellClocks, len(clocks))
+	for node := range clocks {
+		clone[node] = append([][config.NumButtons]Timestamp(nil), clocks[node]...)
+	}
+	return clone
+}
+
+func (clocks CellClocks) HasShape(numElevators, numFloors int) bool {
+	if len(clocks) != numElevators {
+		return false
+	}
+	for node := range clocks {
+		if len(clocks[node]) != numFloors {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary packs the timestamps as varints relative to the newest one, so a sync still fits in one bcast packet.
+//   - Layout: number of nodes, number of floors, newest timestamp, then newest-ts+1 for each cell, or 0 if never changed
+func (clocks CellClocks) MarshalBinary() ([]byte, error) {
+	var newest Timestamp
+	for node := range clocks {
+		for floor := range clocks[node] {
+			for _, ts := range clocks[node][floor] {
+				newest = max(newest, ts)
+			}
+		}
+	}
+
+	buf := binary.AppendUvarint(nil, uint64(len(clocks)))
+	numFloors := 0
+	if len(clocks) > 0 {
+		numFloors = len(clocks[0])
+	}
+	buf = binary.AppendUvarint(buf, uint64(numFloors))
+	buf = binary.AppendUvarint(buf, uint64(newest))
+	for node := range clocks {
+		for floor := range clocks[node] {
+			for _, ts := range clocks[node][floor] {
+				if ts == 0 {
+					buf = binary.AppendUvarint(buf, 0)
+				} else {
+					buf = binary.AppendUvarint(buf, uint64(newest-ts)+1)
+				}
+			}
+		}
+	}
+	return buf, nil
+}
+
+func (clocks *CellClocks) UnmarshalBinary(buf []byte) error {
+	var err error
+	errMalformed := errors.New("malformed cell clocks")
+	next := func() (uint64, error) {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, errMalformed
+		}
+		buf = buf[n:]
+		return value, nil
+	}
+	var header [3]uint64
+	for i := range header {
+		if header[i], err = next(); err != nil {
+			return err
+		}
+	}
+	numElevators, numFloors, newest := header[0], header[1], Timestamp(header[2])
+	// Every cell takes at least one byte. Each dimension is checked first, so the product can not overflow
+	if numElevators > uint64(len(buf)) || numFloors > uint64(len(buf)) ||
+		numElevators*numFloors*config.NumButtons > uint64(len(buf)) {
+		return errMalformed
+	}
+
+	*clocks = NewCellClocks(int(numElevators), int(numFloors))
+	for node := range *clocks {
+		for floor := range (*clocks)[node] {
+			for btn := range config.NumButtons {
+				offset, err := next()
+				if err != nil {
+					return err
+				}
+				if offset > 0 {
+					(*clocks)[node][floor][btn] = newest - Timestamp(offset-1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalJSON sends the binary form as base64, for the JSON bcast encoding
+func (clocks CellClocks) MarshalJSON() ([]byte, error) {
+	buf, err := clocks.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(buf))
+}
+
+func (clocks *CellClocks) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return clocks.UnmarshalBinary(buf)
+}
@@ -0,0 +1,63 @@
+package dispatcher
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"multivator/src/config"
+)
+
+func TestCellClocksRoundTrip(t *testing.T) {
+	clocks := NewCellClocks(config.NumElevators, config.NumFloors)
+	clocks[0][1][2] = newTimestamp(1000, 3)
+	clocks[config.NumElevators-1][config.NumFloors-1][0] = newTimestamp(900, 0)
+
+	buf, err := clocks.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded CellClocks
+	if err := decoded.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.HasShape(config.NumElevators, config.NumFloors) {
+		t.Fatalf("decoded shape %d nodes, want %d", len(decoded), config.NumElevators)
+	}
+	for node := range clocks {
+		for floor := range clocks[node] {
+			if decoded[node][floor] != clocks[node][floor] {
+				t.Errorf("cell [%d][%d] = %v, want %v", node, floor, decoded[node][floor], clocks[node][floor])
+			}
+		}
+	}
+}
+
+func TestCellClocksUnmarshalMalformed(t *testing.T) {
+	header := func(values ...uint64) []byte {
+		var buf []byte
+		for _, value := range values {
+			buf = binary.AppendUvarint(buf, value)
+		}
+		return buf
+	}
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"truncated header", header(1, 2)},
+		{"overflowing size", append(header(1<<63, 2, 0), make([]byte, 2)...)},
+		{"huge elevators without floors", header(1<<40, 0, 0)},
+		{"huge floors", header(1, 1<<40, 0)},
+		{"missing cells", header(2, 2, 0)},
+		{"truncated varint", append(header(1, 1, 0), 0x80, 0x80, 0x80)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var clocks CellClocks
+			if err := clocks.UnmarshalBinary(test.buf); err == nil {
+				t.Errorf("got %d nodes, want an error", len(clocks))
+			}
+		})
+	}
+}
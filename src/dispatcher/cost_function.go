@@ -1,6 +1,9 @@
 package dispatcher
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"multivator/src/config"
@@ -9,37 +12,92 @@ import (
 	"multivator/src/utils"
 )
 
-// timeToserveOrder is called before a bid is stored in bidMap
-//   - returns a high duration if the elevator is obstructed
+// CostFunction is called before a bid is stored in bidMap.
+//   - Bids are compared across nodes, so all nodes must run the same cost function
+type CostFunction interface {
+	Cost(elevator types.ElevState, order types.HallOrder) time.Duration
+}
+
+// unavailableCost is bid by elevators that can not serve orders. Available elevators bid at most
+// maxAvailableCost, so they always win over unavailable ones, however many orders they have
+const (
+	unavailableCost  = 100 * time.Second
+	maxAvailableCost = unavailableCost - time.Millisecond
+)
+
+var costFunctions = map[string]CostFunction{
+	"time":        timeCost{},
+	"nearest":     nearestCarCost{},
+	"load":        loadBalancingCost{},
+	"destination": destinationCost{},
+}
+
+// NewCostFunction is called on startup with the configured cost function name
+func NewCostFunction(name string) (CostFunction, error) {
+	costFn, exists := costFunctions[name]
+	if !exists {
+		names := make([]string, 0, len(costFunctions))
+		for name := range costFunctions {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown cost function %q, must be one of: %s", name, strings.Join(names, ", "))
+	}
+	return cappedCost{costFn}, nil
+}
+
+// cappedCost keeps the costs of available elevators at or below maxAvailableCost
+type cappedCost struct {
+	CostFunction
+}
+
+func (c cappedCost) Cost(elevator types.ElevState, order types.HallOrder) time.Duration {
+	if isUnavailable(elevator) {
+		return unavailableCost
+	}
+	return min(c.CostFunction.Cost(elevator, order), maxAvailableCost)
+}
+
+func isUnavailable(elevator types.ElevState) bool {
+	return elevator.Obstructed || elevator.IsStuck || elevator.EmergencyStop || elevator.DriverLost
+}
+
+// timeCost simulates the elevator until the order is served
+type timeCost struct{}
+
+// Cost returns the estimated time until the order is served
+//   - returns a high duration if the elevator is unavailable
 //   - adjusts the duration based on the next elevator action
 //   - adds time penalty for existing orders
 //   - uses recursive calls, and accumulates the duration for each floor
-func timeToServeOrder(elevator types.ElevState, btnEvent types.HallOrder) time.Duration {
-	if elevator.Obstructed || elevator.IsStuck {
-		return 100 * time.Second
+func (timeCost) Cost(elevator types.ElevState, btnEvent types.HallOrder) time.Duration {
+	if isUnavailable(elevator) {
+		return unavailableCost
 	}
 
 	var duration time.Duration
+	elevator.Orders = elevator.Orders.Clone()
 	elevator.Orders[config.NodeID][btnEvent.Floor][btnEvent.Button] = true
 
 	// Adjust duration based on the next elevator action
 	switch elevator.Behaviour {
 	case types.Idle:
-		elevator.Dir = executor.ChooseDirection(&elevator).Dir
+		elevator.Dir = executor.ChooseDirection(&elevator, config.NodeID).Dir
 		if elevator.Dir == types.MD_Stop {
 			return duration
 		}
 	case types.Moving:
 		duration += config.TravelDuration/2 + config.DoorOpenDuration
-		elevator.Floor += int(elevator.Dir)
+		// Floor is not known yet while the elevator moves down to a floor on startup
+		elevator.Floor = min(max(elevator.Floor+int(elevator.Dir), 0), config.NumFloors-1)
 	case types.DoorOpen:
 		duration += config.DoorOpenDuration / 2
 	}
 
 	// Recursively add travel time and door open time for each floor
 	for {
-		if executor.ShouldStopHere(&elevator) {
-			shouldClear := executor.OrdersToClearHere(&elevator)
+		if executor.ShouldStopHere(&elevator, config.NodeID) {
+			shouldClear := executor.OrdersToClearHere(&elevator, config.NodeID)
 
 			if btnEvent.Floor == elevator.Floor && shouldClear[btnEvent.Button] {
 				// Check if we still have active orders that are not between elevator and target floor
@@ -59,10 +117,110 @@ func timeToServeOrder(elevator types.ElevState, btnEvent types.HallOrder) time.D
 				}
 			}
 			duration += config.DoorOpenDuration
-			elevator.Dir = executor.ChooseDirection(&elevator).Dir
+			elevator.Dir = executor.ChooseDirection(&elevator, config.NodeID).Dir
 		}
 
 		elevator.Floor += int(elevator.Dir)
 		duration += config.TravelDuration
 	}
 }
+
+// nearestCarCost prefers the closest elevator, ignoring existing orders
+type nearestCarCost struct{}
+
+// Cost returns the travel time to the order floor
+//   - adds a direction change penalty if the elevator is moving away from the order
+func (nearestCarCost) Cost(elevator types.ElevState, order types.HallOrder) time.Duration {
+	if isUnavailable(elevator) {
+		return unavailableCost
+	}
+
+	distance := order.Floor - elevator.Floor
+	duration := time.Duration(distance).Abs() * config.TravelDuration
+	if elevator.Behaviour == types.Moving && distance*int(elevator.Dir) < 0 {
+		duration += config.DirChangePenalty
+	}
+	return duration
+}
+
+// loadBalancingCost spreads orders evenly, by weighting the number of assigned orders above distance
+type loadBalancingCost struct{}
+
+// Cost returns the time to serve all existing orders once, plus the travel time to the order floor
+func (loadBalancingCost) Cost(elevator types.ElevState, order types.HallOrder) time.Duration {
+	if isUnavailable(elevator) {
+		return unavailableCost
+	}
+
+	var numOrders int
+	for floor := range elevator.Orders[config.NodeID] {
+		for _, active := range elevator.Orders[config.NodeID][floor] {
+			if active {
+				numOrders++
+			}
+		}
+	}
+	duration := time.Duration(numOrders) * (config.DoorOpenDuration + config.TravelDuration)
+	duration += time.Duration(order.Floor-elevator.Floor).Abs() * config.TravelDuration
+	return duration
+}
+
+// destinationCost only considers cab orders, which are the destinations of passengers already in the elevator.
+//   - Hall orders can still be reassigned, but passengers inside must be delivered first
+type destinationCost struct{}
+
+// Cost sweeps the elevator through its cab destinations in the current direction, then reverses.
+//   - adds door time for every destination passed before the order is served
+//   - the order is served when passed in its own direction, or at the turning point of a sweep
+func (destinationCost) Cost(elevator types.ElevState, order types.HallOrder) time.Duration {
+	if isUnavailable(elevator) {
+		return unavailableCost
+	}
+
+	lowest, highest := order.Floor, order.Floor
+	for floor := range elevator.Orders[config.NodeID] {
+		if elevator.Orders[config.NodeID][floor][types.BT_Cab] {
+			lowest = min(lowest, floor)
+			highest = max(highest, floor)
+		}
+	}
+
+	// An idle elevator starts towards the order
+	dir := elevator.Dir
+	if dir == types.MD_Stop {
+		dir = types.MD_Up
+		if order.Floor < elevator.Floor {
+			dir = types.MD_Down
+		}
+	}
+	orderDir := types.MD_Up
+	if order.Button == types.HallDown {
+		orderDir = types.MD_Down
+	}
+
+	var duration time.Duration
+	floor := elevator.Floor
+	for range 2 * config.NumFloors {
+		turning := dir == types.MD_Up && floor >= highest || dir == types.MD_Down && floor <= lowest
+		if floor == order.Floor && (dir == orderDir || turning) {
+			return duration
+		}
+		if elevator.Orders[config.NodeID][floor][types.BT_Cab] && floor != elevator.Floor {
+			duration += config.DoorOpenDuration
+		}
+		if turning {
+			dir = -dir
+		}
+		floor += int(dir)
+		duration += config.TravelDuration
+	}
+	return duration
+}
+
+// leavingCost is bid while the node hands off its hall orders before leaving, so every order goes to a peer
+type leavingCost struct{}
+
+// Cost returns unavailableCost
+func (leavingCost) Cost(types.ElevState, types.HallOrder) time.Duration {
+	return unavailableCost
+}
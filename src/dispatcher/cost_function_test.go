@@ -0,0 +1,111 @@
+package dispatcher
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"multivator/src/config"
+	"multivator/src/types"
+)
+
+// testElevator returns an elevator at floor with our cab orders at cabFloors
+func testElevator(floor int, behaviour types.ElevBehaviour, dir types.MotorDirection, cabFloors ...int) types.ElevState {
+	elevator := types.ElevState{
+		Floor:     floor,
+		Behaviour: behaviour,
+		Dir:       dir,
+		Orders:    types.NewOrders(config.NumElevators, config.NumFloors),
+	}
+	for _, cabFloor := range cabFloors {
+		elevator.Orders[config.NodeID][cabFloor][types.BT_Cab] = true
+	}
+	return elevator
+}
+
+func TestCostFunctions(t *testing.T) {
+	travel, door := config.TravelDuration, config.DoorOpenDuration
+	stuck := testElevator(0, types.Idle, types.MD_Stop)
+	stuck.IsStuck = true
+	// Every button pressed in a building tall enough to reach unavailableCost
+	busy := testElevator(0, types.Idle, types.MD_Stop)
+	busy.Orders[config.NodeID] = make([][config.NumButtons]bool, int(unavailableCost/(travel+door)))
+	for floor := range busy.Orders[config.NodeID] {
+		busy.Orders[config.NodeID][floor] = [config.NumButtons]bool{true, true, true}
+	}
+
+	up := func(floor int) types.HallOrder { return types.HallOrder{Floor: floor, Button: types.HallUp} }
+	down := func(floor int) types.HallOrder { return types.HallOrder{Floor: floor, Button: types.HallDown} }
+	tests := []struct {
+		name     string
+		costFn   string
+		elevator types.ElevState
+		order    types.HallOrder
+		want     time.Duration
+	}{
+		{"time: idle at the order floor", "time", testElevator(2, types.Idle, types.MD_Stop), up(2), 0},
+		{"time: idle below the order", "time", testElevator(0, types.Idle, types.MD_Stop), up(2), 2 * travel},
+		{"time: cab order on the way", "time", testElevator(0, types.Idle, types.MD_Stop, 1), up(2), 2*travel + door},
+		{"time: unavailable", "time", stuck, up(2), unavailableCost},
+		{"nearest: distance", "nearest", testElevator(3, types.Idle, types.MD_Stop, 0), down(1), 2 * travel},
+		{"nearest: moving away", "nearest", testElevator(1, types.Moving, types.MD_Up), down(0), travel + config.DirChangePenalty},
+		{"nearest: unavailable", "nearest", stuck, up(2), unavailableCost},
+		{"load: orders and distance", "load", testElevator(0, types.Idle, types.MD_Stop, 1, 2), up(3), 2*(door+travel) + 3*travel},
+		{"load: capped below unavailable", "load", busy, up(3), maxAvailableCost},
+		{"load: unavailable", "load", stuck, up(2), unavailableCost},
+		{"destination: on the way", "destination", testElevator(0, types.Idle, types.MD_Stop, 3), up(2), 2 * travel},
+		{"destination: after the turn", "destination", testElevator(0, types.Idle, types.MD_Stop, 3), down(1), 5*travel + door},
+		{"destination: unavailable", "destination", stuck, up(2), unavailableCost},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			costFn, err := NewCostFunction(test.costFn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := costFn.Cost(test.elevator, test.order); got != test.want {
+				t.Errorf("Cost = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewCostFunctionUnknown(t *testing.T) {
+	if _, err := NewCostFunction("fastest"); err == nil {
+		t.Error("accepted an unknown cost function")
+	}
+}
+
+func TestFindAssignee(t *testing.T) {
+	tests := []struct {
+		name  string
+		costs map[int]time.Duration
+		want  int
+	}{
+		{"lowest cost", map[int]time.Duration{0: 5 * time.Second, 1: 2 * time.Second, 2: 3 * time.Second}, 1},
+		{"tie goes to the lowest id", map[int]time.Duration{2: time.Second, 1: time.Second}, 1},
+		{"every bid unavailable", map[int]time.Duration{2: unavailableCost, 1: unavailableCost}, 1},
+		{"bids above unavailable", map[int]time.Duration{2: 2 * unavailableCost}, 2},
+		{"available beats unavailable", map[int]time.Duration{0: unavailableCost, 2: maxAvailableCost}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := findAssignee(BidMapValues{Costs: test.costs}); got != test.want {
+				t.Errorf("findAssignee = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCostFunctionNames(t *testing.T) {
+	for _, name := range config.CostFunctions {
+		if _, exists := costFunctions[name]; !exists {
+			t.Errorf("config.CostFunctions has %q, which is not a cost function", name)
+		}
+	}
+	for name := range costFunctions {
+		if !slices.Contains(config.CostFunctions, name) {
+			t.Errorf("cost function %q is missing from config.CostFunctions", name)
+		}
+	}
+}
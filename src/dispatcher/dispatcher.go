@@ -3,22 +3,26 @@ package dispatcher
 import (
 	"fmt"
 	"slices"
-	"strconv"
 	"sync/atomic"
 	"time"
 
+	"multivator/lib/network/auth"
 	"multivator/lib/network/bcast"
 	"multivator/lib/network/peers"
+	"multivator/lib/network/transport"
 	"multivator/src/config"
 	"multivator/src/types"
 	"multivator/src/utils"
 )
 
-func Run(elevUpdateCh <-chan types.ElevState,
+func Run(costFn CostFunction,
+	tr transport.Transport,
+	elevUpdateCh <-chan types.ElevState,
 	orderUpdateCh chan<- types.Orders,
 	hallOrderCh <-chan types.HallOrder,
 	sendSyncCh <-chan bool,
 	openDoorCh chan<- bool,
+	joinCh <-chan bool,
 ) {
 	bidTxCh := make(chan Msg[Bid])
 	bidTxBufCh := make(chan Msg[Bid])
@@ -28,34 +32,125 @@ func Run(elevUpdateCh <-chan types.ElevState,
 	syncTxBufCh := make(chan Msg[Sync])
 	syncRxCh := make(chan Msg[Sync])
 	syncRxBufCh := make(chan Msg[Sync])
+	stateTxBufCh := make(chan Msg[State])
+	states := bcast.NewChannel[Msg[State]]("state")
+	stateRxBufCh := make(chan Msg[State])
 	peerUpdateCh := make(chan peers.PeerUpdate)
+	metadataCh := make(chan peers.Metadata)
+	transmitEnableCh := make(chan bool)
 	bidTimeoutCh := make(chan types.HallOrder)
 
 	bidMap := make(BidMap)
+	versions := newOrderVersions()
+	stateMap := make(map[int]types.ElevState)
+
+	// States are only shared in central assignment mode
+	central := config.AssignmentMode == "central"
+	var stateTickCh <-chan time.Time
+	if central {
+		stateTickCh = time.NewTicker(config.StateInterval).C
+	}
+	// Assigned hall orders are re-auctioned periodically in bid mode
+	var rebalanceTickCh <-chan time.Time
+	if !central && config.RebalanceInterval > 0 {
+		rebalanceTickCh = time.NewTicker(config.RebalanceInterval).C
+	}
 
 	var peerList peers.PeerUpdate
 	var atomicCounter atomic.Uint64
+	ownID := peers.NodeID(config.NodeID)
+	authenticator := auth.New(config.ClusterKey)
 
-	go bcast.Transmitter(config.BcastPort, bidTxCh, syncTxCh)
-	go bcast.Receiver(config.BcastPort, bidRxCh, syncRxCh)
-	go peers.Transmitter(config.PeersPort, fmt.Sprintf("node-%d", config.NodeID), make(chan bool))
-	go peers.Receiver(config.PeersPort, peerUpdateCh)
+	// Bids and syncs are retransmitted until every peer has acked them. States are periodic, and sent once
+	bidLink := startReliableLink("bid", ownID, bidTxCh, bidRxCh)
+	syncLink := startReliableLink("sync", ownID, syncTxCh, syncRxCh)
+	encoding := new(bcast.EncodingSelector) // JSON until peers are known, see wireEncoding
+	go bcast.Transmitter(tr, config.BcastPort, config.Cluster, encoding, authenticator,
+		bidLink.envelopes, bidLink.acks,
+		syncLink.envelopes, syncLink.acks,
+		states,
+	)
+	go bcast.Receiver(tr, config.BcastPort, config.Cluster, authenticator,
+		bidLink.envelopes, bidLink.acks,
+		syncLink.envelopes, syncLink.acks,
+		states,
+	)
+	detector := peers.Detector{
+		Interval:        config.HeartbeatInterval,
+		SuspectPhi:      config.SuspectPhi,
+		LostPhi:         config.LostPhi,
+		MinDeviation:    config.HeartbeatMinDeviation,
+		AcceptablePause: config.HeartbeatAcceptablePause,
+	}
+	go peers.Transmitter(tr, config.PeersPort, config.Cluster, ownID, authenticator, detector.Interval, transmitEnableCh, metadataCh)
+	go peers.Receiver(tr, config.PeersPort, config.Cluster, authenticator, detector, peerUpdateCh)
 
 	go msgBufferTx(bidTxBufCh, bidTxCh, &atomicCounter)
 	go msgBufferTx(syncTxBufCh, syncTxCh, &atomicCounter)
-	go msgBufferRx(bidRxBufCh, bidRxCh, &atomicCounter)
-	go msgBufferRx(syncRxBufCh, syncRxCh, &atomicCounter)
+	go msgBufferRx(bidRxBufCh, bidRxCh, &atomicCounter, authenticator != nil)
+	go msgBufferRx(syncRxBufCh, syncRxCh, &atomicCounter, authenticator != nil)
+	go msgBufferTx(stateTxBufCh, states.Tx, &atomicCounter)
+	go msgBufferRx(stateRxBufCh, states.Rx, &atomicCounter, authenticator != nil)
 
 	elevator := new(types.ElevState)
 	*elevator = <-elevUpdateCh
+	var sentMetadata peers.Metadata
+	configuredCostFn := costFn
+	state := joined
 
 	for {
+		versions.stamp(elevator.Orders)
+		if metadata := peerMetadata(*elevator); metadata != sentMetadata {
+			metadataCh <- metadata
+			sentMetadata = metadata
+		}
+		if state == leaving && handedOff(bidMap) {
+			fmt.Println("Leaving the cluster")
+			transmitEnableCh <- false
+			state = left
+		}
 		select {
+		case join := <-joinCh:
+			switch {
+			case !join && state == joined && len(peerList.Peers) < 2:
+				fmt.Println("Can not leave the cluster without peers to hand off hall orders to")
+			case !join && state == joined:
+				// Our bids are unavailable from now on, so new and re-auctioned orders go to peers.
+				// In central mode, the coordinator reassigns our orders once we are lost
+				costFn = leavingCost{}
+				state = leaving
+				if !central {
+					rebalanceHallOrders(costFn, elevator, peerList, bidMap, bidTxBufCh, bidTimeoutCh)
+				}
+			case join && state != joined:
+				costFn = configuredCostFn
+				if state == left {
+					fmt.Println("Joining the cluster")
+					transmitEnableCh <- true
+				}
+				state = joined
+			}
+
 		case elevUpdate := <-elevUpdateCh:
-			*elevator = elevUpdate
+			versions.countServedHallOrders(elevator, elevUpdate)
+			mergeElevUpdate(elevator, elevUpdate, central)
 
 		case hallOrder := <-hallOrderCh:
+			if state == left {
+				// Peers no longer hear us, so the order could not be confirmed, and would only be served by us
+				fmt.Println("Ignoring hall order while out of service")
+				continue
+			}
+			switch hallOrderState(bidMap, elevator.Orders, hallOrder) {
+			case HallServing:
+				continue // Already confirmed and assigned
+			case HallUnconfirmed:
+				if bidMap[hallOrder].Owner == config.NodeID {
+					continue // Already announced by us, and waiting for replies
+				}
+			}
 			createHallOrder(
+				costFn,
 				elevator,
 				peerList,
 				hallOrder,
@@ -66,10 +161,26 @@ func Run(elevUpdateCh <-chan types.ElevState,
 			)
 
 		case bidRx := <-bidRxBufCh:
+			if !isValidBid(bidRx) {
+				continue
+			}
+			if state == left && !slices.Contains(peerList.Peers, peers.NodeID(bidRx.SenderID)) {
+				continue
+			}
 			switch bidRx.Content.Type {
-			case BidInitial:
+			case BidInitial, BidRebalance:
+				// Our reply also acknowledges the order to every other peer
 				storeBid(bidRx, bidMap)
-				cost := timeToServeOrder(*elevator, bidRx.Content.Order)
+				entry := bidMap[bidRx.Content.Order]
+				entry.Owner = bidRx.SenderID
+				entry.Rebalance = bidRx.Content.Type == BidRebalance
+				entry.State = HallUnconfirmed
+				bidMap[bidRx.Content.Order] = entry
+				if state == left {
+					break // We are not in the peer list of others, so they do not expect our reply
+				}
+
+				cost := costFn.Cost(*elevator, bidRx.Content.Order)
 				bidEntry := Msg[Bid]{
 					SenderID: config.NodeID,
 					Content:  Bid{Type: BidReply, Order: bidRx.Content.Order, Cost: cost},
@@ -83,74 +194,96 @@ func Run(elevUpdateCh <-chan types.ElevState,
 			numBids := len(bidMap[bidRx.Content.Order].Costs)
 			numPeers := len(peerList.Peers)
 			if numBids == numPeers {
-				// All bids are received. Stop bid timer and find assignee
-				bidEntry := bidMap[bidRx.Content.Order]
+				// All bids are received, so every live peer knows the order. Stop bid timer and find assignee
+				order := bidRx.Content.Order
+				bidEntry := bidMap[order]
 				if bidEntry.Timer != nil {
 					bidEntry.Timer.Stop()
 				}
-
-				assignee := findAssignee(bidEntry)
-				if assignee == config.NodeID {
-					// If we are on the same floor in the correct direction, only open the door
-					order := bidRx.Content.Order
-					if elevator.Floor == order.Floor &&
-						(elevator.Dir == types.MD_Up && order.Button == types.HallUp ||
-							elevator.Dir == types.MD_Down && order.Button == types.HallDown) &&
-						!elevator.BetweenFloors &&
-						!elevator.IsStuck {
-
-						openDoorCh <- true
-						continue
-					}
-					elevator.Orders[assignee][bidRx.Content.Order.Floor][bidRx.Content.Order.Button] = true
-					orderUpdateCh <- elevator.Orders
-				} else if bidEntry.Costs[assignee] != 0 {
-					elevator.Orders[assignee][bidRx.Content.Order.Floor][bidRx.Content.Order.Button] = true
-					orderUpdateCh <- elevator.Orders
+				delete(bidMap, order)
+				if bidEntry.Rebalance {
+					moveHallOrder(elevator, versions, bidEntry, order, orderUpdateCh)
+					continue
 				}
-				delete(bidMap, bidRx.Content.Order)
+				bidEntry.State = HallConfirmed
+				assignHallOrder(elevator, bidEntry, order, central, openDoorCh, orderUpdateCh)
 			}
 
 		case syncRx := <-syncRxBufCh:
-			// Sync received orders
-			utils.ForEachOrder(syncRx.Content.Orders, func(node, floor, btn int) {
-				receivedOrder := syncRx.Content.Orders[node][floor][btn]
-				if elevator.Orders[node][floor][btn] != receivedOrder {
-					switch types.ButtonType(btn) {
-					case types.BT_Cab: // Cab orders from other elevators are overwritten
-						if node != config.NodeID {
-							elevator.Orders[node][floor][btn] = receivedOrder
-						} else if syncRx.Content.Type == SyncCab { // Own cab oders are merged on SyncCab, which is received on network init
-							elevator.Orders[node][floor][btn] = elevator.Orders[node][floor][btn] ||
-								receivedOrder
-						}
-					default: // Hall orders are overwritten
-						if node != config.NodeID {
-							elevator.Orders[node][floor][btn] = receivedOrder
-						}
-					}
-				}
-			})
-			orderUpdateCh <- elevator.Orders
+			if !syncRx.Content.isValid() {
+				continue
+			}
+			// Newer changes are taken from every row. Lost cab orders are restored this way after a restart,
+			// since our own cells have not changed since then
+			versions.merge(elevator.Orders, syncRx.Content)
+			orderUpdateCh <- elevator.Orders.Clone()
 
-		case <-sendSyncCh:
-			syncTxBufCh <- Msg[Sync]{
-				Content:  Sync{Type: SyncOrders, Orders: elevator.Orders},
+		case <-stateTickCh:
+			stateMap[config.NodeID] = *elevator
+			stateTxBufCh <- Msg[State]{
+				Content:  State{Elevator: elevator.Clone()},
 				SenderID: config.NodeID,
 			}
+			if nodes := liveNodes(peerList, stateMap); isCoordinator(nodes) && state != left {
+				reassignHallOrders(costFn, elevator, versions, stateMap, nodes, orderUpdateCh, syncTxBufCh)
+			}
+
+		case stateRx := <-stateRxBufCh:
+			if stateRx.Content.Elevator.Orders.HasShape(config.NumElevators, config.NumFloors) {
+				stateMap[stateRx.SenderID] = stateRx.Content.Elevator
+			}
+
+		case <-sendSyncCh:
+			syncTxBufCh <- versions.sync(SyncOrders, elevator.Orders)
+
+		case <-rebalanceTickCh:
+			rebalanceHallOrders(costFn, elevator, peerList, bidMap, bidTxBufCh, bidTimeoutCh)
 
 		case order := <-bidTimeoutCh:
-			if entry, exists := bidMap[order]; exists {
-				elevator.Orders[config.NodeID][order.Floor][order.Button] = true
-				orderUpdateCh <- elevator.Orders
-				if entry.Timer != nil {
-					entry.Timer.Stop()
+			entry, exists := bidMap[order]
+			if !exists {
+				continue
+			}
+			delete(bidMap, order)
+			if entry.Rebalance {
+				continue // Not all peers replied, so the order stays with us
+			}
+			if entry.Rounds >= maxBidRounds {
+				if len(entry.Costs) < 2 {
+					// No peer has replied, so only we know the order. It stays unlit until the peers change
+					entry.State = HallNew
+					entry.Timer = nil
+					bidMap[order] = entry
+					continue
 				}
-				delete(bidMap, order)
+				// Peers that never reply, such as over a one-way link, are no longer waited for. Every peer
+				// that replied knows the order, and learns the assignment from our sync
+				entry.State = HallConfirmed
+				assignHallOrder(elevator, entry, order, central, openDoorCh, orderUpdateCh)
+				syncTxBufCh <- versions.sync(SyncOrders, elevator.Orders)
+				continue
+			}
+			// Not all peers have confirmed the order. Announce it again to the current peers,
+			// which no longer include lost peers, or take it if we are alone
+			createHallOrder(
+				costFn,
+				elevator,
+				peerList,
+				order,
+				bidMap,
+				bidTxBufCh,
+				bidTimeoutCh,
+				orderUpdateCh,
+			)
+			if retry, exists := bidMap[order]; exists {
+				retry.Rounds = entry.Rounds + 1
+				bidMap[order] = retry
 			}
 
 		case peerUpdate := <-peerUpdateCh:
-			ownID := fmt.Sprintf("node-%d", config.NodeID)
+			bidLink.setPeers(peerUpdate.Peers)
+			syncLink.setPeers(peerUpdate.Peers)
+			encoding.Set(wireEncoding(peerUpdate))
 			// Print status on network init or network loss
 			if peerUpdate.New == ownID ||
 				slices.Contains(peerList.Peers, ownID) &&
@@ -160,26 +293,27 @@ func Run(elevUpdateCh <-chan types.ElevState,
 
 			// If we detect change from prevLostPeers to update.New, sync cab orders
 			if slices.Contains(peerList.Lost, peerUpdate.New) {
-				syncTxBufCh <- Msg[Sync]{
-					Content:  Sync{Type: SyncCab, Orders: elevator.Orders},
-					SenderID: config.NodeID,
-				}
+				syncTxBufCh <- versions.sync(SyncCab, elevator.Orders)
 			}
 
-			// If a node goes from PeerUpdate.Peers to PeerUpdate.Lost, overtake active hall orders
+			// If a node goes from PeerUpdate.Peers to PeerUpdate.Lost, overtake active hall orders.
+			// Loss is confirmed by the failure detector. Suspected peers are still in Peers, and keep their orders.
+			// In central mode, the coordinator reassigns them on the next state tick instead
 			for _, lostPeer := range peerUpdate.Lost {
-				if !slices.Contains(peerList.Peers, lostPeer) {
+				if central {
+					break
+				}
+				if !slices.Contains(peerList.Peers, lostPeer) || !inBuilding(lostPeer) || lostPeer == ownID && state == left {
 					continue
 				}
 
-				// Get the last digit of node-<digit> to get the node ID integer
-				lostPeerInt, _ := strconv.Atoi(lostPeer[5:])
 				utils.ForEachOrder(elevator.Orders, func(node, floor, btn int) {
-					if node == lostPeerInt &&
+					if node == int(lostPeer) &&
 						types.ButtonType(btn) != types.BT_Cab &&
-						elevator.Orders[lostPeerInt][floor][btn] {
+						elevator.Orders[node][floor][btn] {
 						hallOrder := types.HallOrder{Floor: floor, Button: types.HallType(btn)}
 						createHallOrder(
+							costFn,
 							elevator,
 							peerList,
 							hallOrder,
@@ -192,14 +326,128 @@ func Run(elevUpdateCh <-chan types.ElevState,
 				})
 			}
 			peerList = peerUpdate
+
+			// Orders that no peer replied to are announced again to the new peers
+			for order, entry := range bidMap {
+				if entry.State == HallNew && entry.Owner == config.NodeID {
+					createHallOrder(
+						costFn,
+						elevator,
+						peerList,
+						order,
+						bidMap,
+						bidTxBufCh,
+						bidTimeoutCh,
+						orderUpdateCh,
+					)
+				}
+			}
+
+			// A new peer may be closer to our waiting passengers
+			if !central && peerUpdate.New != peers.NoNode && peerUpdate.New != ownID {
+				rebalanceHallOrders(costFn, elevator, peerList, bidMap, bidTxBufCh, bidTimeoutCh)
+			}
+		}
+	}
+}
+
+// mergeElevUpdate is called on state updates from the executor.
+//   - The executor only changes its own row, so the orders of other nodes are kept
+//   - In central mode, own hall orders that the coordinator has moved to another node are not taken back
+func mergeElevUpdate(elevator *types.ElevState, elevUpdate types.ElevState, central bool) {
+	orders := elevator.Orders
+	*elevator = elevUpdate
+	for node := range orders {
+		if node != config.NodeID {
+			elevator.Orders[node] = orders[node]
+		}
+	}
+	if !central {
+		return
+	}
+	for floor := range config.NumFloors {
+		for btn := range types.BT_Cab {
+			for node := range orders {
+				if node != config.NodeID && orders[node][floor][btn] {
+					elevator.Orders[config.NodeID][floor][btn] = false
+				}
+			}
 		}
 	}
 }
 
-// createHallOrder is called on: hall orders, overtake lost peers hall orders
-//   - If we are alone, take the order immediately.
+// Number of bid rounds for a new order, after which peers that have not replied are no longer waited for
+const maxBidRounds = 3
+
+// Hall order lifecycle, see HallOrderState: the bid round doubles as acknowledgement, since every live peer
+// replies to it. While the round runs, the order is unconfirmed, and only exists in bidMap with the hall lamp off.
+// Once every live peer has replied, it is confirmed and written to the Orders row of its assignee. The executor
+// lights hall lamps from that matrix, so a lit button is known by every live peer, and is taken over if its
+// elevator is lost. The order is serving until it is served and cleared from the matrix.
+//   - After maxBidRounds, peers that never replied are dropped from the peers that must confirm the order
+//   - If no peer replied at all, the order is new again, and stays unlit until the peers change
+
+// hallOrderState returns the lifecycle state of order.
+//   - Confirmed orders are written to Orders at once, so they are found as serving
+func hallOrderState(bidMap BidMap, orders types.Orders, order types.HallOrder) HallOrderState {
+	for node := range orders {
+		if orders[node][order.Floor][order.Button] {
+			return HallServing
+		}
+	}
+	if entry, exists := bidMap[order]; exists {
+		return entry.State
+	}
+	return HallCleared
+}
+
+// assignHallOrder is called when the bid round of a new order is complete.
+//   - Only confirmed orders are written to Orders, since that lights the hall lamp
+//   - In central mode, the coordinator reassigns the order from the row of the node that announced it
+//   - If the assignee is at the floor in the direction of the order, it only opens its door
+func assignHallOrder(
+	elevator *types.ElevState,
+	bidEntry BidMapValues,
+	order types.HallOrder,
+	central bool,
+	openDoorCh chan<- bool,
+	orderUpdateCh chan<- types.Orders,
+) {
+	if bidEntry.State != HallConfirmed {
+		return
+	}
+	if central {
+		elevator.Orders[bidEntry.Owner][order.Floor][order.Button] = true
+		orderUpdateCh <- elevator.Orders.Clone()
+		return
+	}
+
+	assignee := findAssignee(bidEntry)
+	if assignee == config.NodeID {
+		// If we are on the same floor in the correct direction, only open the door
+		if elevator.Floor == order.Floor &&
+			(elevator.Dir == types.MD_Up && order.Button == types.HallUp ||
+				elevator.Dir == types.MD_Down && order.Button == types.HallDown) &&
+			!elevator.BetweenFloors &&
+			!elevator.IsStuck {
+
+			openDoorCh <- true
+			return
+		}
+		elevator.Orders[assignee][order.Floor][order.Button] = true
+		orderUpdateCh <- elevator.Orders.Clone()
+	} else if bidEntry.Costs[assignee] != 0 {
+		// Else, the assignee only opens its door
+		elevator.Orders[assignee][order.Floor][order.Button] = true
+		orderUpdateCh <- elevator.Orders.Clone()
+	}
+}
+
+// createHallOrder is called on: hall orders, overtake lost peers hall orders, bid timeouts, and peer updates for new orders
+//   - If we are alone, we are every live peer, so the order is confirmed and taken immediately.
 //   - Else, start a bidding timeout, store own bid, and send the bid to the network.
 func createHallOrder(
+	costFn CostFunction,
 	elevator *types.ElevState,
 	peerList peers.PeerUpdate,
 	hallOrder types.HallOrder,
@@ -208,9 +456,13 @@ func createHallOrder(
 	bidTimeoutCh chan<- types.HallOrder,
 	orderUpdateCh chan<- types.Orders,
 ) {
+	if entry, exists := bidMap[hallOrder]; exists && entry.Timer != nil {
+		entry.Timer.Stop()
+	}
 	if len(peerList.Peers) < 2 {
+		delete(bidMap, hallOrder)
 		elevator.Orders[config.NodeID][hallOrder.Floor][hallOrder.Button] = true
-		orderUpdateCh <- elevator.Orders
+		orderUpdateCh <- elevator.Orders.Clone()
 		return
 	}
 
@@ -219,7 +471,7 @@ func createHallOrder(
 		bidTimeoutCh <- hallOrder
 	})
 
-	cost := timeToServeOrder(*elevator, hallOrder)
+	cost := costFn.Cost(*elevator, hallOrder)
 	bidEntry := Msg[Bid]{
 		SenderID: config.NodeID,
 		Content:  Bid{Type: BidInitial, Order: hallOrder, Cost: cost},
@@ -229,11 +481,23 @@ func createHallOrder(
 	// Maps are reference types, so we can update it here directly
 	entry := bidMap[hallOrder]
 	entry.Timer = timer
+	entry.Owner = config.NodeID
+	entry.Rebalance = false
+	entry.Rounds = 1
+	entry.State = HallUnconfirmed
 	bidMap[hallOrder] = entry
 
 	bidTxBufCh <- bidEntry
 }
 
+// isValidBid returns false for bids from nodes configured with a different building size
+func isValidBid(msg Msg[Bid]) bool {
+	order := msg.Content.Order
+	return msg.SenderID >= 0 && inBuilding(peers.NodeID(msg.SenderID)) &&
+		order.Floor >= 0 && order.Floor < config.NumFloors &&
+		order.Button >= types.HallUp && order.Button <= types.HallDown
+}
+
 // storeBid is called on hall orders, initial bids, and reply bids.
 //   - Creates or stores the bid in the bidMap.
 func storeBid(msg Msg[Bid], bidMap BidMap) {
@@ -252,11 +516,12 @@ func storeBid(msg Msg[Bid], bidMap BidMap) {
 // findAssignee is called when all bids are received.
 //   - Chooses the elevator with the lowest cost as the assignee.
 //   - In case of equal costs, the elevator with the lowest ID is chosen.
+//   - The assignee is always one of the bidders, even if every bid is unavailableCost.
 func findAssignee(bidEntry BidMapValues) int {
-	lowestCost := 100 * time.Second
-	var assignee int
+	var lowestCost time.Duration
+	assignee := -1
 	for nodeID, cost := range bidEntry.Costs {
-		if cost < lowestCost || (cost == lowestCost && nodeID < assignee) {
+		if assignee == -1 || cost < lowestCost || (cost == lowestCost && nodeID < assignee) {
 			lowestCost = cost
 			assignee = nodeID
 		}
@@ -0,0 +1,62 @@
+package dispatcher
+
+import (
+	"testing"
+	"time"
+
+	"multivator/src/config"
+	"multivator/src/types"
+)
+
+func TestHallOrderState(t *testing.T) {
+	order := types.HallOrder{Floor: 2, Button: types.HallUp}
+	orders := types.NewOrders(config.NumElevators, config.NumFloors)
+	bidMap := make(BidMap)
+
+	if got := hallOrderState(bidMap, orders, order); got != HallCleared {
+		t.Errorf("unknown order is %v, want HallCleared", got)
+	}
+	bidMap[order] = BidMapValues{State: HallUnconfirmed}
+	if got := hallOrderState(bidMap, orders, order); got != HallUnconfirmed {
+		t.Errorf("announced order is %v, want HallUnconfirmed", got)
+	}
+	orders[config.NumElevators-1][order.Floor][order.Button] = true
+	if got := hallOrderState(bidMap, orders, order); got != HallServing {
+		t.Errorf("order in the row of an elevator is %v, want HallServing", got)
+	}
+}
+
+func TestAssignHallOrder(t *testing.T) {
+	order := types.HallOrder{Floor: 2, Button: types.HallDown}
+	other := (config.NodeID + 1) % config.NumElevators
+	costs := map[int]time.Duration{config.NodeID: 5 * time.Second, other: time.Second}
+
+	tests := []struct {
+		name    string
+		state   HallOrderState
+		central bool
+		want    int // Row that gets the order, -1 for none
+	}{
+		{"unconfirmed is not lit", HallUnconfirmed, false, -1},
+		{"new is not lit", HallNew, false, -1},
+		{"confirmed goes to the lowest bid", HallConfirmed, false, other},
+		{"central goes to the owner row", HallConfirmed, true, config.NodeID},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			elevator := testElevator(0, types.Idle, types.MD_Stop)
+			orderUpdateCh := make(chan types.Orders, 1)
+			bidEntry := BidMapValues{Costs: costs, Owner: config.NodeID, State: test.state}
+			assignHallOrder(&elevator, bidEntry, order, test.central, make(chan bool, 1), orderUpdateCh)
+
+			for node := range elevator.Orders {
+				if got := elevator.Orders[node][order.Floor][order.Button]; got != (node == test.want) {
+					t.Errorf("order in row %d is %v, want %v", node, got, node == test.want)
+				}
+			}
+			if sent := len(orderUpdateCh) == 1; sent != (test.want >= 0) {
+				t.Errorf("sent order update %v, want %v", sent, test.want >= 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package dispatcher
+
+import (
+	"multivator/src/config"
+)
+
+// Graceful leave: on leave, the node re-auctions its hall orders while bidding unavailableCost, so every order
+// moves to a peer. Once no re-auction of ours is pending, the heartbeat stops with a goodbye, and peers drop the
+// node at once instead of waiting for the failure detector. Orders that could not be handed off are taken over
+// as from a lost peer. On join, the heartbeat resumes, and peers restore our cab orders as after a restart.
+
+// membership is the progress of a leave
+type membership int
+
+const (
+	joined  membership = iota
+	leaving            // Handing off hall orders, the heartbeat is still sent
+	left               // The heartbeat is stopped
+)
+
+// handedOff returns true when no re-auction of our hall orders is pending
+func handedOff(bidMap BidMap) bool {
+	for _, entry := range bidMap {
+		if entry.Owner == config.NodeID && entry.Rebalance {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package dispatcher
+
+import (
+	"multivator/src/config"
+	"multivator/src/types"
+)
+
+// Every order cell is versioned with the hybrid logical clock timestamp of its last change, see clock.go.
+// On received syncs, a cell is only taken if the received change is newer, so an older set never
+// overwrites a newer clear, regardless of the order the syncs arrive in.
+//
+// Hall orders are also reconciled with the time they were last served, which every node sends with its syncs.
+// A hall order set in any row before it was last served is cleared, since the elevator that served it
+// picked up its passengers. Sets made after the last serve are kept, so when a network partition heals,
+// orders accepted on either side are not dropped, and orders served on either side are not revived.
+// Timestamps follow the wall clock, so this also holds for a node that restarts and accepts orders while alone.
+
+// orderVersions holds the versions of the orders in the dispatcher
+type orderVersions struct {
+	clock  hlc
+	clocks CellClocks
+	known  types.Orders // Orders as of the last stamp, to detect local changes
+	served HallServed
+}
+
+func newOrderVersions() *orderVersions {
+	return &orderVersions{
+		clocks: NewCellClocks(config.NumElevators, config.NumFloors),
+		known:  types.NewOrders(config.NumElevators, config.NumFloors),
+		served: NewHallServed(config.NumFloors),
+	}
+}
+
+// stamp is called before handling each event, and before syncs are sent or merged.
+//   - Gives every cell changed since the last stamp a new timestamp
+func (versions *orderVersions) stamp(orders types.Orders) {
+	var now Timestamp
+	for node := range orders {
+		for floor := range orders[node] {
+			for btn := range config.NumButtons {
+				if orders[node][floor][btn] == versions.known[node][floor][btn] {
+					continue
+				}
+				if now == 0 {
+					now = versions.clock.now()
+				}
+				versions.clocks[node][floor][btn] = now
+				versions.known[node][floor][btn] = orders[node][floor][btn]
+			}
+		}
+	}
+}
+
+// sync stamps the orders and returns a sync message with copies of the orders and their versions
+func (versions *orderVersions) sync(syncType SyncType, orders types.Orders) Msg[Sync] {
+	versions.stamp(orders)
+	return Msg[Sync]{
+		Content: Sync{
+			Type:   syncType,
+			Orders: orders.Clone(),
+			Clocks: versions.clocks.Clone(),
+			Served: versions.served.Clone(),
+		},
+		SenderID: config.NodeID,
+	}
+}
+
+// isValid returns false for syncs from nodes configured with a different building size
+func (sync Sync) isValid() bool {
+	return sync.Orders.HasShape(config.NumElevators, config.NumFloors) &&
+		sync.Clocks.HasShape(config.NumElevators, config.NumFloors) &&
+		len(sync.Served) == config.NumFloors
+}
+
+// merge is called on received syncs.
+//   - Each cell is taken if the received change is newer than ours
+//   - Hall orders set before the last serve known to either side are cleared
+func (versions *orderVersions) merge(orders types.Orders, sync Sync) {
+	versions.stamp(orders)
+	var newest Timestamp
+	for node := range orders {
+		for floor := range config.NumFloors {
+			for btn := range config.NumButtons {
+				if sync.Clocks[node][floor][btn] > versions.clocks[node][floor][btn] {
+					orders[node][floor][btn] = sync.Orders[node][floor][btn]
+					versions.known[node][floor][btn] = sync.Orders[node][floor][btn]
+					versions.clocks[node][floor][btn] = sync.Clocks[node][floor][btn]
+					newest = max(newest, sync.Clocks[node][floor][btn])
+				}
+			}
+		}
+	}
+	for floor := range config.NumFloors {
+		for btn := range types.BT_Cab {
+			versions.served[floor][btn] = max(versions.served[floor][btn], sync.Served[floor][btn])
+			newest = max(newest, sync.Served[floor][btn])
+		}
+	}
+	versions.clearServed(orders)
+	// Changes made after this sync are newer than everything we have taken from it
+	versions.clock.update(newest)
+}
+
+// clearServed clears hall orders in every row that were set before the order was last served.
+//   - The clock of the cell is kept, so every node clears the same sets, and newer sets are still taken
+func (versions *orderVersions) clearServed(orders types.Orders) {
+	for node := range orders {
+		for floor := range config.NumFloors {
+			for btn := range types.BT_Cab {
+				if orders[node][floor][btn] && versions.clocks[node][floor][btn] <= versions.served[floor][btn] {
+					orders[node][floor][btn] = false
+					versions.known[node][floor][btn] = false
+				}
+			}
+		}
+	}
+}
+
+// countServedHallOrders is called on state updates from the executor, before they are merged.
+//   - Own hall orders cleared while the door opens at their floor are served, and their serve time is recorded
+//   - Orders given away by the executor are cleared elsewhere, and do not count
+//   - Copies of served orders in other rows are cleared, for example after a lost peer takeover
+func (versions *orderVersions) countServedHallOrders(elevator *types.ElevState, elevUpdate types.ElevState) {
+	if elevUpdate.Behaviour != types.DoorOpen || elevUpdate.BetweenFloors {
+		return
+	}
+	floor := elevUpdate.Floor
+	for btn := range types.BT_Cab {
+		if elevator.Orders[config.NodeID][floor][btn] && !elevUpdate.Orders[config.NodeID][floor][btn] {
+			versions.served[floor][btn] = versions.clock.now()
+			for node := range elevator.Orders {
+				elevator.Orders[node][floor][btn] = false
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package dispatcher
+
+import (
+	"testing"
+	"time"
+
+	"multivator/src/config"
+	"multivator/src/types"
+)
+
+// partitionEvent changes the orders of one side of a network partition, at a given wall clock millisecond
+type partitionEvent struct {
+	serve bool // Serve the hall order at floor and btn, else set it in the row of node
+	node  int
+	floor int
+	btn   types.ButtonType
+	at    uint64
+}
+
+type cell struct {
+	node  int
+	floor int
+	btn   types.ButtonType
+}
+
+// partitionSide replays events with explicit timestamps, as orderVersions would stamp them
+func partitionSide(events []partitionEvent) (*orderVersions, types.Orders) {
+	versions := newOrderVersions()
+	orders := types.NewOrders(config.NumElevators, config.NumFloors)
+	for _, event := range events {
+		if event.serve {
+			versions.served[event.floor][event.btn] = newTimestamp(event.at, 0)
+			for node := range orders {
+				if orders[node][event.floor][event.btn] {
+					orders[node][event.floor][event.btn] = false
+					versions.clocks[node][event.floor][event.btn] = newTimestamp(event.at, 1)
+				}
+			}
+		} else {
+			orders[event.node][event.floor][event.btn] = true
+			versions.clocks[event.node][event.floor][event.btn] = newTimestamp(event.at, 0)
+		}
+		versions.known = orders.Clone()
+	}
+	return versions, orders
+}
+
+func TestMergeAfterPartition(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []partitionEvent
+		want []cell
+	}{
+		{
+			name: "heal after split keeps orders accepted on both sides",
+			a:    []partitionEvent{{node: 0, floor: 1, btn: types.BT_HallUp, at: 100}},
+			b:    []partitionEvent{{node: 1, floor: 2, btn: types.BT_HallDown, at: 110}},
+			want: []cell{{0, 1, types.BT_HallUp}, {1, 2, types.BT_HallDown}},
+		},
+		{
+			name: "served order is not revived by a takeover on the other side",
+			a: []partitionEvent{
+				{node: 1, floor: 2, btn: types.BT_HallUp, at: 100},
+				{serve: true, floor: 2, btn: types.BT_HallUp, at: 200},
+			},
+			b: []partitionEvent{
+				{node: 1, floor: 2, btn: types.BT_HallUp, at: 100},
+				{node: 2, floor: 2, btn: types.BT_HallUp, at: 150},
+			},
+			want: nil,
+		},
+		{
+			name: "order accepted after the last serve on the other side is not dropped",
+			a: []partitionEvent{
+				{node: 0, floor: 2, btn: types.BT_HallUp, at: 50},
+				{serve: true, floor: 2, btn: types.BT_HallUp, at: 100},
+				{node: 0, floor: 2, btn: types.BT_HallUp, at: 300},
+			},
+			b: []partitionEvent{
+				{node: 1, floor: 2, btn: types.BT_HallUp, at: 60},
+				{serve: true, floor: 2, btn: types.BT_HallUp, at: 150},
+				{node: 1, floor: 2, btn: types.BT_HallUp, at: 170},
+				{serve: true, floor: 2, btn: types.BT_HallUp, at: 200},
+			},
+			want: []cell{{0, 2, types.BT_HallUp}},
+		},
+		{
+			name: "order accepted before a serve on the other side is cleared",
+			a:    []partitionEvent{{node: 0, floor: 3, btn: types.BT_HallDown, at: 150}},
+			b: []partitionEvent{
+				{node: 1, floor: 3, btn: types.BT_HallDown, at: 100},
+				{serve: true, floor: 3, btn: types.BT_HallDown, at: 200},
+			},
+			want: nil,
+		},
+		{
+			name: "cab orders are not affected by hall serves",
+			a:    []partitionEvent{{node: 0, floor: 2, btn: types.BT_Cab, at: 100}},
+			b:    []partitionEvent{{serve: true, floor: 2, btn: types.BT_HallUp, at: 200}},
+			want: []cell{{0, 2, types.BT_Cab}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			versionsA, ordersA := partitionSide(test.a)
+			versionsB, ordersB := partitionSide(test.b)
+			syncA := versionsA.sync(SyncOrders, ordersA)
+			syncB := versionsB.sync(SyncOrders, ordersB)
+			versionsA.merge(ordersA, syncB.Content)
+			versionsB.merge(ordersB, syncA.Content)
+
+			want := types.NewOrders(config.NumElevators, config.NumFloors)
+			for _, c := range test.want {
+				want[c.node][c.floor][c.btn] = true
+			}
+			for side, orders := range map[string]types.Orders{"A": ordersA, "B": ordersB} {
+				for node := range want {
+					for floor := range want[node] {
+						if orders[node][floor] != want[node][floor] {
+							t.Errorf("side %s: orders[%d][%d] = %v, want %v", side, node, floor, orders[node][floor], want[node][floor])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMergeIgnoresOlderSyncs(t *testing.T) {
+	versions, orders := partitionSide([]partitionEvent{
+		{node: 1, floor: 1, btn: types.BT_HallDown, at: 100},
+		{serve: true, floor: 1, btn: types.BT_HallDown, at: 200},
+	})
+	old, oldOrders := partitionSide([]partitionEvent{{node: 1, floor: 1, btn: types.BT_HallDown, at: 100}})
+
+	versions.merge(orders, old.sync(SyncOrders, oldOrders).Content)
+	if orders[1][1][types.BT_HallDown] {
+		t.Error("an older sync revived a served order")
+	}
+}
+
+func TestTimestampSuccessor(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   Timestamp
+		want Timestamp
+	}{
+		{"increments the logical counter", packTimestamp(100, 3, 1), packTimestamp(100, 4, 1)},
+		{"carries into the wall clock", packTimestamp(100, maxLogical, 2), packTimestamp(101, 0, 2)},
+		{"keeps the node bits", packTimestamp(100, 0, maxNode), packTimestamp(100, 1, maxNode)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.ts.successor(); got != test.want || got <= test.ts {
+				t.Errorf("successor = %x, want %x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeAfterMove(t *testing.T) {
+	const owner, assignee, floor, btn = 1, 2, 2, types.BT_HallUp
+	order := types.HallOrder{Floor: floor, Button: types.HallType(btn)}
+	bidEntry := BidMapValues{
+		Costs: map[int]time.Duration{0: 10 * time.Second, owner: 10 * time.Second, assignee: time.Second},
+		Owner: owner,
+	}
+	accepted := packTimestamp(100, maxLogical, owner)
+	side := func(servedAt uint64) (*orderVersions, *types.ElevState) {
+		versions := newOrderVersions()
+		elevator := &types.ElevState{Orders: types.NewOrders(config.NumElevators, config.NumFloors)}
+		elevator.Orders[owner][floor][btn] = true
+		versions.clocks[owner][floor][btn] = accepted
+		if servedAt != 0 {
+			versions.served[floor][btn] = newTimestamp(servedAt, 0)
+			elevator.Orders[owner][floor][btn] = false
+			versions.clocks[owner][floor][btn] = newTimestamp(servedAt, 1)
+		}
+		versions.known = elevator.Orders.Clone()
+		moveHallOrder(elevator, versions, bidEntry, order, make(chan types.Orders, 1))
+		return versions, elevator
+	}
+	exchange := func(versionsA *orderVersions, a *types.ElevState, versionsB *orderVersions, b *types.ElevState) {
+		syncA := versionsA.sync(SyncOrders, a.Orders)
+		syncB := versionsB.sync(SyncOrders, b.Orders)
+		versionsA.merge(a.Orders, syncB.Content)
+		versionsB.merge(b.Orders, syncA.Content)
+	}
+
+	t.Run("every node stamps the moved order the same", func(t *testing.T) {
+		versionsA, a := side(0)
+		versionsB, b := side(0)
+		if versionsA.clocks[assignee][floor][btn] != accepted.successor() ||
+			versionsB.clocks[assignee][floor][btn] != accepted.successor() {
+			t.Fatalf("assignee stamps %x and %x, want %x",
+				versionsA.clocks[assignee][floor][btn], versionsB.clocks[assignee][floor][btn], accepted.successor())
+		}
+		exchange(versionsA, a, versionsB, b)
+		for name, elevator := range map[string]*types.ElevState{"A": a, "B": b} {
+			if !elevator.Orders[assignee][floor][btn] || elevator.Orders[owner][floor][btn] {
+				t.Errorf("side %s: order not moved from node %d to node %d", name, owner, assignee)
+			}
+		}
+	})
+
+	t.Run("serve during the auction clears the moved order", func(t *testing.T) {
+		versionsA, a := side(0)
+		versionsB, b := side(200)
+		if b.Orders[assignee][floor][btn] {
+			t.Fatal("moved an order that was served during the auction")
+		}
+		exchange(versionsA, a, versionsB, b)
+		for name, elevator := range map[string]*types.ElevState{"A": a, "B": b} {
+			if elevator.Orders[assignee][floor][btn] || elevator.Orders[owner][floor][btn] {
+				t.Errorf("side %s: served order was revived", name)
+			}
+		}
+	})
+}
@@ -5,63 +5,144 @@ import (
 	"sync/atomic"
 	"time"
 
+	"multivator/lib/network/bcast"
+	"multivator/lib/network/peers"
+	"multivator/lib/network/reliable"
 	"multivator/src/config"
 )
 
+// session is sent with every message, so peers can tell our messages apart from those of an earlier run
+var session = time.Now().UnixNano()
+
 // msgBufferTx is called as a goroutine multiple times for each message type
 //   - increments monotonic counter for each input message
+//   - the counter is at least the wall clock in microseconds, and is pushed by the counters of peers
 func msgBufferTx[T MsgContent](
 	msgBufTxCh chan Msg[T],
 	msgTxCh chan Msg[T], atomicCounter *atomic.Uint64,
 ) {
 	for msgBufTx := range msgBufTxCh {
-		msgBufTx.Counter = atomicCounter.Add(1)
-		for range config.MsgRepetitions {
-			msgTxCh <- msgBufTx
-			time.Sleep(config.MsgInterval)
+		for {
+			localTime := atomicCounter.Load()
+			newTime := max(localTime+1, uint64(time.Now().UnixMicro()))
+			if atomicCounter.CompareAndSwap(localTime, newTime) {
+				msgBufTx.Counter = newTime
+				msgBufTx.Session = session
+				break
+			}
 		}
+		msgTxCh <- msgBufTx
 	}
 }
 
 // msgBufferRx is called as a goroutine multiple times for each message type
 //   - ignores own messages
+//   - drops replayed messages if rejectReplays is set
 //   - implements lamport timestamp for causal ordering
-//   - uses a circular buffer to prevent duplicate messages
 func msgBufferRx[T MsgContent](
 	msgBufRxCh chan Msg[T],
 	msgRxCh chan Msg[T],
 	atomicCounter *atomic.Uint64,
+	rejectReplays bool,
 ) {
-	seenMsgs := make(map[string]bool)
-	recentMsgIDs := make([]string, config.MsgRepetitions)
-	var nextIndex int
-
+	replays := newReplayFilter()
 	for msgRx := range msgRxCh {
 		if msgRx.SenderID == config.NodeID {
 			continue
 		}
-		msgID := fmt.Sprintf("%d-%d", msgRx.SenderID, msgRx.Counter)
-		if !seenMsgs[msgID] {
-			seenMsgs[msgID] = true
-			// Update Lamport timestamp
-			for {
-				localTime := atomicCounter.Load()
-				newTime := max(localTime, msgRx.Counter) + 1
-				if atomicCounter.CompareAndSwap(localTime, newTime) {
-					break
-				}
+		if rejectReplays && replays.isReplay(msgRx.SenderID, msgRx.Session, msgRx.Counter, uint64(time.Now().UnixMicro())) {
+			continue
+		}
+		// Update Lamport timestamp
+		for {
+			localTime := atomicCounter.Load()
+			newTime := max(localTime, msgRx.Counter) + 1
+			if atomicCounter.CompareAndSwap(localTime, newTime) {
+				break
 			}
+		}
+		msgBufRxCh <- msgRx
+	}
+}
 
-			// Delete old message from seen messages
-			index := nextIndex
-			oldMsgID := recentMsgIDs[index]
-			if oldMsgID != "" {
-				delete(seenMsgs, oldMsgID)
-			}
-			recentMsgIDs[index] = msgID
-			nextIndex = (nextIndex + 1) % config.MsgRepetitions
+// Authenticated messages can still be recorded and sent again. Counters of received messages are
+// remembered per sender session within replayWindow of the newest one. A counter that was already seen,
+// is older than the window, or comes from an older session of the sender, is a replay. Counters may
+// be pushed ahead of the wall clock by peers, so they only need to increase within a session.
+// They are never behind the wall clock of the sender, so the first counter of a session must be
+// within replayWindow of our wall clock. Otherwise old messages could be replayed after we restart.
+const replayWindow = 10_000_000
+
+type replaySender struct {
+	session int64
+	newest  uint64
+	seen    map[uint64]bool
+}
 
-			msgBufRxCh <- msgRx
+type replayFilter struct {
+	senders map[int]*replaySender
+	count   uint64
+}
+
+func newReplayFilter() *replayFilter {
+	return &replayFilter{senders: make(map[int]*replaySender)}
+}
+
+// isReplay returns true for replayed messages, and remembers the counter otherwise.
+//   - Retransmitted copies are already removed by reliable delivery, so each counter is received once
+//   - A newer session means the sender restarted, and its counters start over
+//   - now is our wall clock in microseconds
+func (filter *replayFilter) isReplay(sender int, session int64, counter uint64, now uint64) bool {
+	s, exists := filter.senders[sender]
+	if !exists || session > s.session {
+		s = &replaySender{session: session, seen: make(map[uint64]bool), newest: now}
+		filter.senders[sender] = s
+	}
+	if session < s.session || s.seen[counter] || counter+replayWindow <= s.newest {
+		filter.count++
+		if filter.count&(filter.count-1) == 0 {
+			fmt.Printf("Dropped replayed message from node %d (%d dropped in total)\n", sender, filter.count)
 		}
+		return true
+	}
+	s.seen[counter] = true
+	if counter > s.newest {
+		s.newest = counter
+		for seen := range s.seen {
+			if seen+replayWindow <= counter {
+				delete(s.seen, seen)
+			}
+		}
+	}
+	return false
+}
+
+// reliableLink holds the bcast channels of one message type sent with reliable delivery
+type reliableLink[T MsgContent] struct {
+	envelopes bcast.Channel[reliable.Envelope[Msg[T]]]
+	acks      bcast.Channel[reliable.Ack[Msg[T]]]
+	peersCh   chan []string
+}
+
+// startReliableLink starts reliable delivery of the messages on msgTxCh, and delivers received messages once on msgRxCh.
+//   - Envelopes are sent on topic, and acks on topic + "-ack"
+//   - The returned channels must be passed to bcast, and every peer list must be given to setPeers
+func startReliableLink[T MsgContent](topic string, ownID peers.NodeID, msgTxCh chan Msg[T], msgRxCh chan Msg[T]) reliableLink[T] {
+	link := reliableLink[T]{
+		envelopes: bcast.NewChannel[reliable.Envelope[Msg[T]]](topic),
+		acks:      bcast.NewChannel[reliable.Ack[Msg[T]]](topic + "-ack"),
+		peersCh:   make(chan []string),
+	}
+	go reliable.Transmitter(ownID.String(), config.MsgInterval, msgTxCh, link.envelopes.Tx, link.acks.Rx, link.peersCh)
+	go reliable.Receiver(ownID.String(), link.envelopes.Rx, link.acks.Tx, msgRxCh)
+	return link
+}
+
+// setPeers is called on peer updates. Envelopes and acks name nodes as in heartbeats
+func (link reliableLink[T]) setPeers(peerIDs []peers.NodeID) {
+	names := make([]string, len(peerIDs))
+	for i, peer := range peerIDs {
+		names[i] = peer.String()
 	}
+	link.peersCh <- names
 }
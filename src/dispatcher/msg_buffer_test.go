@@ -0,0 +1,66 @@
+package dispatcher
+
+import "testing"
+
+func TestReplayFilter(t *testing.T) {
+	type receive struct {
+		session int64
+		counter uint64
+		replay  bool
+	}
+	tests := []struct {
+		name     string
+		now      uint64
+		received []receive
+	}{
+		{
+			name:     "duplicate counter is a replay",
+			now:      100,
+			received: []receive{{1, 100, false}, {1, 101, false}, {1, 100, true}},
+		},
+		{
+			name:     "counter older than the window is a replay",
+			now:      100,
+			received: []receive{{1, 100, false}, {1, 100 + replayWindow, false}, {1, 99, true}},
+		},
+		{
+			name: "restart after counters were pushed ahead of the wall clock",
+			now:  10 * replayWindow,
+			received: []receive{
+				{1, 50 * replayWindow, false},
+				{2, 10 * replayWindow, false},
+				{2, 10*replayWindow + 1, false},
+			},
+		},
+		{
+			name:     "older session is a replay",
+			now:      100,
+			received: []receive{{1, 100, false}, {2, 50, false}, {1, 200, true}},
+		},
+		{
+			name:     "old message of a sender we have not heard from is a replay",
+			now:      100 * replayWindow,
+			received: []receive{{1, 10 * replayWindow, true}, {1, 99 * replayWindow, true}},
+		},
+		{
+			name:     "first message of a sender within the window is accepted",
+			now:      100 * replayWindow,
+			received: []receive{{1, 100*replayWindow - replayWindow/2, false}},
+		},
+		{
+			name:     "first message of a sender with its clock ahead is accepted",
+			now:      10 * replayWindow,
+			received: []receive{{1, 50 * replayWindow, false}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter := newReplayFilter()
+			for i, msg := range test.received {
+				if got := filter.isReplay(1, msg.session, msg.counter, test.now); got != msg.replay {
+					t.Errorf("message %d: isReplay = %v, want %v", i, got, msg.replay)
+				}
+			}
+		})
+	}
+}
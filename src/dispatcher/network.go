@@ -0,0 +1,86 @@
+package dispatcher
+
+import (
+	"fmt"
+
+	"multivator/lib/network/auth"
+	"multivator/lib/network/bcast"
+	"multivator/lib/network/peers"
+	"multivator/lib/network/transport"
+	"multivator/src/config"
+	"multivator/src/types"
+)
+
+// NewTransport returns the transport for peers and bcast selected by name: broadcast, multicast or unicast
+func NewTransport(name string) (transport.Transport, error) {
+	switch name {
+	case "broadcast":
+		return transport.Broadcast(), nil
+	case "multicast":
+		return transport.Multicast(config.MulticastGroup)
+	case "unicast":
+		return transport.Unicast(config.UnicastHosts, config.NodeID)
+	}
+	return nil, fmt.Errorf("unknown transport %q, must be broadcast, multicast or unicast", name)
+}
+
+// ClaimNodeID is called on startup, before Run, so we never join with the id of another running node.
+//   - With autoID, claims the lowest free id, and sets config.NodeID to it
+func ClaimNodeID(tr transport.Transport, autoID bool) error {
+	candidates := []peers.NodeID{peers.NodeID(config.NodeID)}
+	if autoID {
+		candidates = make([]peers.NodeID, config.NumElevators)
+		for node := range candidates {
+			candidates[node] = peers.NodeID(node)
+		}
+	}
+	id, err := peers.Claim(tr, config.PeersPort, config.Cluster, auth.New(config.ClusterKey),
+		candidates, config.HeartbeatInterval, config.IDClaimDuration)
+	if err != nil {
+		return err
+	}
+	config.NodeID = int(id)
+	return nil
+}
+
+// inBuilding returns true if the peer has a row in the orders, so peers with ids above the configured
+// number of elevators are ignored
+func inBuilding(peer peers.NodeID) bool {
+	return int(peer) < config.NumElevators
+}
+
+// peerMetadata returns the metadata sent with our heartbeats
+func peerMetadata(elevator types.ElevState) peers.Metadata {
+	numOrders := 0
+	for floor := range elevator.Orders[config.NodeID] {
+		for btn := range config.NumButtons {
+			if elevator.Orders[config.NodeID][floor][btn] {
+				numOrders++
+			}
+		}
+	}
+	return peers.Metadata{
+		Version:    config.Version,
+		Floor:      elevator.Floor,
+		Behaviour:  elevator.Behaviour.String(),
+		Stuck:      elevator.IsStuck,
+		Obstructed: elevator.Obstructed,
+		Orders:     numOrders,
+		Binary:     true,
+	}
+}
+
+// wireEncoding is called on every peer update.
+//   - Returns the binary encoding if it is preferred, and every peer has advertised that it decodes it
+//   - Falls back to JSON while any peer, such as a node of an older version, has not
+func wireEncoding(peerList peers.PeerUpdate) bcast.Encoding {
+	if preferred, _ := bcast.ParseEncoding(config.WireEncoding); preferred == bcast.JSON { // Validated on startup
+		return bcast.JSON
+	}
+	for _, peer := range peerList.Peers {
+		if peer != peers.NodeID(config.NodeID) && !peerList.Metadata[peer].Binary {
+			return bcast.JSON
+		}
+	}
+	return bcast.Binary
+}
@@ -0,0 +1,63 @@
+package dispatcher
+
+import (
+	"testing"
+
+	"multivator/lib/network/bcast"
+	"multivator/lib/network/peers"
+	"multivator/src/config"
+)
+
+func TestWireEncoding(t *testing.T) {
+	own := peers.NodeID(config.NodeID)
+	tests := []struct {
+		name      string
+		preferred string
+		peerList  peers.PeerUpdate
+		want      bcast.Encoding
+	}{
+		{"alone", "binary", peers.PeerUpdate{Peers: []peers.NodeID{own}}, bcast.Binary},
+		{
+			name:      "every peer decodes binary",
+			preferred: "binary",
+			peerList: peers.PeerUpdate{
+				Peers:    []peers.NodeID{own, 1},
+				Metadata: map[peers.NodeID]peers.Metadata{1: {Binary: true}},
+			},
+			want: bcast.Binary,
+		},
+		{
+			name:      "older peer",
+			preferred: "binary",
+			peerList: peers.PeerUpdate{
+				Peers:    []peers.NodeID{own, 1, 2},
+				Metadata: map[peers.NodeID]peers.Metadata{1: {Binary: true}, 2: {}},
+			},
+			want: bcast.JSON,
+		},
+		{
+			name:      "peer without metadata yet",
+			preferred: "binary",
+			peerList:  peers.PeerUpdate{Peers: []peers.NodeID{own, 1}},
+			want:      bcast.JSON,
+		},
+		{
+			name:      "json preferred",
+			preferred: "json",
+			peerList: peers.PeerUpdate{
+				Peers:    []peers.NodeID{own, 1},
+				Metadata: map[peers.NodeID]peers.Metadata{1: {Binary: true}},
+			},
+			want: bcast.JSON,
+		},
+	}
+	defer func(preferred string) { config.WireEncoding = preferred }(config.WireEncoding)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.WireEncoding = test.preferred
+			if got := wireEncoding(test.peerList); got != test.want {
+				t.Errorf("wireEncoding = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
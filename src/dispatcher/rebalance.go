@@ -0,0 +1,90 @@
+package dispatcher
+
+import (
+	"time"
+
+	"multivator/lib/network/peers"
+	"multivator/src/config"
+	"multivator/src/types"
+)
+
+// Rebalancing in bid mode: each node re-auctions the hall orders assigned to it, periodically
+// and when a peer joins. All nodes receive the same bids, so they agree on whether the order moves.
+
+// rebalanceHallOrders is called on rebalance ticks and when a new peer joins.
+//   - Starts a re-auction for each own hall order, unless it is being served or already auctioned
+//   - The order stays in our row during the auction, so the hall lamp stays lit
+func rebalanceHallOrders(
+	costFn CostFunction,
+	elevator *types.ElevState,
+	peerList peers.PeerUpdate,
+	bidMap BidMap,
+	bidTxBufCh chan<- Msg[Bid],
+	bidTimeoutCh chan<- types.HallOrder,
+) {
+	if len(peerList.Peers) < 2 {
+		return
+	}
+	for floor := range config.NumFloors {
+		for btn := range types.BT_Cab {
+			order := types.HallOrder{Floor: floor, Button: types.HallType(btn)}
+			if !elevator.Orders[config.NodeID][floor][btn] {
+				continue
+			}
+			if _, exists := bidMap[order]; exists {
+				continue
+			}
+			if elevator.Floor == floor && elevator.Behaviour == types.DoorOpen {
+				continue
+			}
+
+			timer := time.AfterFunc(config.BidTimeout, func() {
+				bidTimeoutCh <- order
+			})
+			bidEntry := Msg[Bid]{
+				SenderID: config.NodeID,
+				Content:  Bid{Type: BidRebalance, Order: order, Cost: costFn.Cost(*elevator, order)},
+			}
+			storeBid(bidEntry, bidMap)
+			entry := bidMap[order]
+			entry.Timer = timer
+			entry.Rebalance = true
+			entry.Owner = config.NodeID
+			bidMap[order] = entry
+
+			bidTxBufCh <- bidEntry
+		}
+	}
+}
+
+// moveHallOrder is called when all bids of a re-auction are received.
+//   - The order only moves if the lowest bid beats the owner by more than config.ReassignThreshold
+//   - The assignee and owner rows are changed in one update, so the hall lamp stays lit
+//   - Every node checks that the owner row still holds the order, accepted after it was last served,
+//     so an order served during the auction is not revived
+//   - The assignee row is stamped just after the owner row, not now, so a serve merged later still clears it
+func moveHallOrder(
+	elevator *types.ElevState,
+	versions *orderVersions,
+	bidEntry BidMapValues,
+	order types.HallOrder,
+	orderUpdateCh chan<- types.Orders,
+) {
+	assignee := findAssignee(bidEntry)
+	owner := bidEntry.Owner
+	if assignee == owner || bidEntry.Costs[owner]-bidEntry.Costs[assignee] <= config.ReassignThreshold {
+		return
+	}
+	if !elevator.Orders[owner][order.Floor][order.Button] {
+		return
+	}
+	accepted := versions.clocks[owner][order.Floor][order.Button]
+	if accepted <= versions.served[order.Floor][order.Button] {
+		return
+	}
+	elevator.Orders[assignee][order.Floor][order.Button] = true
+	versions.clocks[assignee][order.Floor][order.Button] = max(accepted, versions.clocks[assignee][order.Floor][order.Button]).successor()
+	versions.known[assignee][order.Floor][order.Button] = true
+	elevator.Orders[owner][order.Floor][order.Button] = false
+	orderUpdateCh <- elevator.Orders.Clone()
+}
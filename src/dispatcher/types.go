@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"slices"
 	"time"
 
 	"multivator/src/types"
@@ -12,10 +13,11 @@ type Msg[Content MsgContent] struct {
 	SenderID int
 	Content  Content
 	Counter  uint64
+	Session  int64 // Changes when the sender restarts, see replayFilter
 }
 
 type MsgContent interface {
-	Bid | Sync
+	Bid | Sync | State
 }
 
 type (
@@ -26,11 +28,13 @@ type (
 const (
 	BidInitial BidType = iota
 	BidReply
+	BidRebalance // Initial bid when the owner re-auctions an assigned hall order
 )
 
 const (
-	SyncOrders SyncType = iota // Sync without restoring cab orders
-	SyncCab                    // Sync with restoring cab orders
+	SyncOrders     SyncType = iota // Sync without restoring cab orders
+	SyncCab                        // Sync with restoring cab orders
+	SyncAssignment                 // Hall order assignment from the coordinator in central mode
 )
 
 type Bid struct {
@@ -42,13 +46,46 @@ type Bid struct {
 type Sync struct {
 	Type   SyncType
 	Orders types.Orders
+	Clocks CellClocks
+	Served HallServed
+}
+
+// State is broadcast periodically in central assignment mode
+type State struct {
+	Elevator types.ElevState
 }
 
 // Local types
 
 type BidMapValues struct {
-	Costs map[int]time.Duration
-	Timer *time.Timer
+	Costs     map[int]time.Duration
+	Timer     *time.Timer
+	Rebalance bool           // The order is already assigned to Owner, and is only moved if another node is faster
+	Owner     int            // The node that started the bid round
+	Rounds    int            // Number of bid rounds started by us for the order, see maxBidRounds
+	State     HallOrderState // HallNew, HallUnconfirmed or HallConfirmed. See hallOrderState
 }
 
 type BidMap map[types.HallOrder]BidMapValues
+
+// HallOrderState is the lifecycle of a hall order. Only confirmed orders are written to Orders, which lights the lamp
+type HallOrderState int
+
+const (
+	HallCleared     HallOrderState = iota // Not in bidMap or Orders, such as after it is served
+	HallNew                               // Only known by us, since no peer replied to its bid rounds. Announced again when the peers change
+	HallUnconfirmed                       // Announced in a bid round, and waiting for a reply from every live peer
+	HallConfirmed                         // Every live peer has replied, so it may be written to Orders
+	HallServing                           // In the Orders row of its assignee, until served
+)
+
+// HallServed holds the timestamp of the last time each hall order was served, indexed [floor][btn]. See merge.go
+type HallServed [][types.BT_Cab]Timestamp
+
+func NewHallServed(numFloors int) HallServed {
+	return make(HallServed, numFloors)
+}
+
+func (served HallServed) Clone() HallServed {
+	return slices.Clone(served)
+}
@@ -10,7 +10,33 @@ import (
 	"multivator/src/utils"
 )
 
-func Run(elevUpdateCh chan<- types.ElevState,
+// Config holds the settings of one executor, so several executors can run in one process
+type Config struct {
+	NodeID           int // Row of this elevator in Orders
+	NumElevators     int
+	NumFloors        int
+	PollRate         time.Duration // Of the buttons and sensors of the ElevatorIO
+	DoorOpenDuration time.Duration
+	StuckTimeout     time.Duration
+	StateDir         string // Directory for the persisted cab orders. Empty disables persistence
+}
+
+// NewConfig returns the executor settings of this node, as loaded on startup
+func NewConfig() Config {
+	return Config{
+		NodeID:           config.NodeID,
+		NumElevators:     config.NumElevators,
+		NumFloors:        config.NumFloors,
+		PollRate:         config.SensorPollRate,
+		DoorOpenDuration: config.DoorOpenDuration,
+		StuckTimeout:     config.StuckTimeout,
+		StateDir:         config.StateDir,
+	}
+}
+
+func Run(cfg Config,
+	io elevio.ElevatorIO,
+	elevUpdateCh chan<- types.ElevState,
 	orderUpdateCh <-chan types.Orders,
 	hallOrderCh chan<- types.HallOrder,
 	sendSyncCh chan<- bool,
@@ -19,43 +45,75 @@ func Run(elevUpdateCh chan<- types.ElevState,
 	drvButtonsCh := make(chan types.ButtonEvent)
 	drvFloorsCh := make(chan int)
 	drvObstrCh := make(chan bool)
+	drvConnCh := make(chan bool)
+	drvStopCh := make(chan bool)
 	var doorTimer *time.Timer
 	doorTimeoutCh := make(chan bool)
 	var stuckTimer *time.Timer
 	stuckTimeoutCh := make(chan bool)
 
-	port := config.PeersPort + config.NodeID
-	elevio.Init(fmt.Sprintf("localhost:%d", port), config.NumFloors)
-
 	elevator := new(types.ElevState)
-	initElevPos(elevator, &stuckTimer, stuckTimeoutCh)
+	elevator.Orders = types.NewOrders(cfg.NumElevators, cfg.NumFloors)
+	initElevPos(cfg, io, elevator, &stuckTimer, stuckTimeoutCh)
 
-	go elevio.PollButtons(drvButtonsCh)
-	go elevio.PollFloorSensor(drvFloorsCh)
-	go elevio.PollObstructionSwitch(drvObstrCh)
+	// Restore cab orders saved before a crash or restart
+	store := newCabStore(cfg)
+	cabOrders, err := store.load()
+	if err != nil {
+		fmt.Println("Could not restore cab orders:", err)
+	}
+	for floor, order := range cabOrders {
+		elevator.Orders[cfg.NodeID][floor][types.BT_Cab] = order
+	}
+	restoreLights(cfg, io, elevator)
+	chooseAction(cfg, io,
+		elevator,
+		doorTimer,
+		doorTimeoutCh,
+		&stuckTimer,
+		stuckTimeoutCh,
+	)
+
+	go elevio.PollButtons(io, cfg.NumFloors, cfg.PollRate, drvButtonsCh)
+	go elevio.PollFloorSensor(io, cfg.PollRate, drvFloorsCh)
+	go elevio.PollObstructionSwitch(io, cfg.PollRate, drvObstrCh)
+	go elevio.PollConnection(io, cfg.PollRate, drvConnCh)
+	go elevio.PollStopButton(io, cfg.PollRate, drvStopCh)
 
-	elevUpdateCh <- *elevator
+	elevUpdateCh <- elevator.Clone()
 
 	for {
+		if err := store.save(elevator.Orders); err != nil {
+			fmt.Println("Could not save cab orders:", err)
+		}
 		select {
 
 		case receivedOrders := <-orderUpdateCh:
-			syncLights(elevator, receivedOrders)
+			syncLights(cfg, io, elevator, receivedOrders)
 			elevator.Orders = receivedOrders
-			chooseAction(elevator,
+			chooseAction(cfg, io,
+				elevator,
 				doorTimer,
 				doorTimeoutCh,
 				&stuckTimer,
 				stuckTimeoutCh,
 			)
-			elevUpdateCh <- *elevator
+			elevUpdateCh <- elevator.Clone()
 
 		case btn := <-drvButtonsCh:
 			switch types.ButtonType(btn.Button) {
 			case types.BT_Cab:
+				// Cab orders are kept during emergency stop, and served after reset
+				if elevator.EmergencyStop {
+					elevator.Orders[cfg.NodeID][btn.Floor][btn.Button] = true
+					io.SetButtonLamp(types.BT_Cab, btn.Floor, true)
+					elevUpdateCh <- elevator.Clone()
+					sendSyncCh <- true
+					continue
+				}
 				// If we are on the same floor in the correct motor direction, only open the door
 				if elevator.Floor == btn.Floor && !elevator.BetweenFloors {
-					openDoor(
+					openDoor(cfg, io,
 						elevator,
 						&doorTimer,
 						doorTimeoutCh,
@@ -63,15 +121,16 @@ func Run(elevUpdateCh chan<- types.ElevState,
 					continue
 				}
 
-				elevator.Orders[config.NodeID][btn.Floor][btn.Button] = true
-				elevio.SetButtonLamp(types.BT_Cab, btn.Floor, true)
-				chooseAction(elevator,
+				elevator.Orders[cfg.NodeID][btn.Floor][btn.Button] = true
+				io.SetButtonLamp(types.BT_Cab, btn.Floor, true)
+				chooseAction(cfg, io,
+					elevator,
 					doorTimer,
 					doorTimeoutCh,
 					&stuckTimer,
 					stuckTimeoutCh,
 				)
-				elevUpdateCh <- *elevator
+				elevUpdateCh <- elevator.Clone()
 				sendSyncCh <- true
 			default:
 				hallOrderCh <- types.HallOrder{
@@ -86,40 +145,44 @@ func Run(elevUpdateCh chan<- types.ElevState,
 			if stuckTimer != nil {
 				stuckTimer.Stop()
 			}
-			elevio.SetFloorIndicator(floor)
+			io.SetFloorIndicator(floor)
 
-			if ShouldStopHere(elevator) {
-				elevio.SetMotorDirection(types.MD_Stop)
+			if ShouldStopHere(elevator, cfg.NodeID) {
+				io.SetMotorDirection(types.MD_Stop)
 				elevator.BetweenFloors = false
-				clearAtCurrentFloor(elevator)
-				openDoor(elevator, &doorTimer, doorTimeoutCh)
-				elevUpdateCh <- *elevator
+				clearAtCurrentFloor(io, elevator, cfg.NodeID)
+				openDoor(cfg, io, elevator, &doorTimer, doorTimeoutCh)
+				elevUpdateCh <- elevator.Clone()
 				sendSyncCh <- true
 			}
 
 		case isObstructed := <-drvObstrCh:
 			elevator.Obstructed = isObstructed
-			if elevator.Behaviour == types.DoorOpen || elevator.IsStuck {
-				openDoor(elevator, &doorTimer, doorTimeoutCh)
+			if !elevator.EmergencyStop && elevator.Behaviour == types.DoorOpen || elevator.IsStuck {
+				openDoor(cfg, io, elevator, &doorTimer, doorTimeoutCh)
 				if elevator.Obstructed {
-					giveHallOrders(elevator, hallOrderCh, elevUpdateCh)
+					giveHallOrders(cfg, elevator, hallOrderCh, elevUpdateCh)
 				}
 			}
-			elevUpdateCh <- *elevator
+			elevUpdateCh <- elevator.Clone()
 		case <-doorTimeoutCh:
+			if elevator.EmergencyStop {
+				continue // The door stays open until reset
+			}
 			if elevator.Obstructed {
-				openDoor(elevator, &doorTimer, doorTimeoutCh)
+				openDoor(cfg, io, elevator, &doorTimer, doorTimeoutCh)
 				continue
 			}
-			elevio.SetDoorOpenLamp(false)
+			io.SetDoorOpenLamp(false)
 			elevator.Behaviour = types.Idle
-			chooseAction(elevator,
+			chooseAction(cfg, io,
+				elevator,
 				doorTimer,
 				doorTimeoutCh,
 				&stuckTimer,
 				stuckTimeoutCh,
 			)
-			elevUpdateCh <- *elevator
+			elevUpdateCh <- elevator.Clone()
 			sendSyncCh <- true
 
 		case <-stuckTimeoutCh:
@@ -128,11 +191,78 @@ func Run(elevUpdateCh chan<- types.ElevState,
 			if doorTimer != nil {
 				stuckTimer.Stop()
 			}
-			giveHallOrders(elevator, hallOrderCh, elevUpdateCh)
+			giveHallOrders(cfg, elevator, hallOrderCh, elevUpdateCh)
+
+		case connected := <-drvConnCh:
+			elevator.DriverLost = !connected
+			if !elevator.EmergencyStop {
+				elevator.Behaviour = types.Idle
+			}
+			if !connected {
+				// The elevator server stops the motor on disconnect. Give away hall orders until it is back
+				if doorTimer != nil {
+					doorTimer.Stop()
+				}
+				if stuckTimer != nil {
+					stuckTimer.Stop()
+				}
+				giveHallOrders(cfg, elevator, hallOrderCh, elevUpdateCh)
+				elevUpdateCh <- elevator.Clone()
+				continue
+			}
+			// The elevator server may have restarted, so restore position and all lamps
+			if elevator.EmergencyStop {
+				// The motor stays stopped and the door keeps its state. resetEmergencyStop finds the floor
+				io.SetMotorDirection(types.MD_Stop)
+				io.SetStopLamp(true)
+				io.SetDoorOpenLamp(elevator.Behaviour == types.DoorOpen)
+				if !elevator.BetweenFloors {
+					io.SetFloorIndicator(elevator.Floor)
+				}
+				restoreLights(cfg, io, elevator)
+				elevUpdateCh <- elevator.Clone()
+				continue
+			}
+			io.SetDoorOpenLamp(false)
+			io.SetStopLamp(false)
+			initElevPos(cfg, io, elevator, &stuckTimer, stuckTimeoutCh)
+			restoreLights(cfg, io, elevator)
+			chooseAction(cfg, io,
+				elevator,
+				doorTimer,
+				doorTimeoutCh,
+				&stuckTimer,
+				stuckTimeoutCh,
+			)
+			elevUpdateCh <- elevator.Clone()
+			sendSyncCh <- true
+
+		case stopPressed := <-drvStopCh:
+			if !stopPressed {
+				continue // Emergency stop is toggled on press, not on release
+			}
+			if !elevator.EmergencyStop {
+				startEmergencyStop(io, elevator, doorTimer, stuckTimer)
+				giveHallOrders(cfg, elevator, hallOrderCh, elevUpdateCh)
+				elevUpdateCh <- elevator.Clone()
+				continue
+			}
+			resetEmergencyStop(cfg, io,
+				elevator,
+				&doorTimer,
+				doorTimeoutCh,
+				&stuckTimer,
+				stuckTimeoutCh,
+			)
+			elevUpdateCh <- elevator.Clone()
+			sendSyncCh <- true
 
 		case <-openDoorCh:
-			openDoor(elevator, &doorTimer, doorTimeoutCh)
-			elevUpdateCh <- *elevator
+			if elevator.EmergencyStop {
+				continue
+			}
+			openDoor(cfg, io, elevator, &doorTimer, doorTimeoutCh)
+			elevUpdateCh <- elevator.Clone()
 		}
 	}
 }
@@ -140,24 +270,27 @@ func Run(elevUpdateCh chan<- types.ElevState,
 // initElevPos is called on startup.
 //   - If between floors, moves elevator down
 //   - If on floor, sets floor indicator
-func initElevPos(elevator *types.ElevState, stuckTimer **time.Timer, stuckTimeoutCh chan<- bool) {
-	floor := elevio.GetFloor()
+func initElevPos(cfg Config, io elevio.ElevatorIO, elevator *types.ElevState, stuckTimer **time.Timer, stuckTimeoutCh chan<- bool) {
+	floor := io.GetFloor()
+	elevator.BetweenFloors = floor == -1
 	if floor == -1 {
-		elevator.BetweenFloors = true
-		resetTimer(stuckTimer, stuckTimeoutCh, config.StuckTimeout)
-		elevio.SetMotorDirection(types.MD_Down)
+		resetTimer(stuckTimer, stuckTimeoutCh, cfg.StuckTimeout)
+		io.SetMotorDirection(types.MD_Down)
 		elevator.Behaviour = types.Moving
 		elevator.Dir = types.MD_Down
 	} else {
 		elevator.Floor = floor
-		elevio.SetFloorIndicator(floor)
+		io.SetFloorIndicator(floor)
 	}
 }
 
 // chooseAction is called on order updates from dispatcher, on cab calls and on door timeouts.
 //   - Moves elevator if we have orders in different floors
 //   - Opens door if we have orders here
-func chooseAction(elevator *types.ElevState,
+func chooseAction(
+	cfg Config,
+	io elevio.ElevatorIO,
+	elevator *types.ElevState,
 	doorTimer *time.Timer,
 	doorTimeoutCh chan<- bool,
 	stuckTimer **time.Timer,
@@ -166,33 +299,108 @@ func chooseAction(elevator *types.ElevState,
 	if elevator.Behaviour != types.Idle {
 		return // chooseAction will be called again when the elevator becomes idle
 	}
-	pair := ChooseDirection(elevator)
+	if elevator.DriverLost || elevator.EmergencyStop {
+		return // chooseAction will be called again on reconnect or emergency stop reset
+	}
+	pair := ChooseDirection(elevator, cfg.NodeID)
 	elevator.Behaviour = pair.Behaviour
 	elevator.Dir = pair.Dir
 
 	switch pair.Behaviour {
 	case types.Moving:
 		elevator.BetweenFloors = true
-		elevio.SetMotorDirection(elevator.Dir)
-		resetTimer(stuckTimer, stuckTimeoutCh, config.StuckTimeout)
+		io.SetMotorDirection(elevator.Dir)
+		resetTimer(stuckTimer, stuckTimeoutCh, cfg.StuckTimeout)
 
 	case types.DoorOpen:
-		clearAtCurrentFloor(elevator)
-		openDoor(elevator, &doorTimer, doorTimeoutCh)
+		clearAtCurrentFloor(io, elevator, cfg.NodeID)
+		openDoor(cfg, io, elevator, &doorTimer, doorTimeoutCh)
 	default:
-		elevio.SetMotorDirection(types.MD_Stop)
+		io.SetMotorDirection(types.MD_Stop)
 	}
 }
 
+// startEmergencyStop is called when the stop button is pressed in normal operation.
+//   - Stops the motor and lights the stop lamp
+//   - If at a floor, the door is opened and kept open. Between floors, the door stays closed
+func startEmergencyStop(io elevio.ElevatorIO, elevator *types.ElevState, doorTimer *time.Timer, stuckTimer *time.Timer) {
+	io.SetMotorDirection(types.MD_Stop)
+	io.SetStopLamp(true)
+	elevator.EmergencyStop = true
+	elevator.IsStuck = false
+	if stuckTimer != nil {
+		stuckTimer.Stop()
+	}
+	if doorTimer != nil {
+		doorTimer.Stop()
+	}
+
+	if floor := io.GetFloor(); floor != -1 {
+		elevator.Floor = floor
+		elevator.BetweenFloors = false
+		elevator.Behaviour = types.DoorOpen
+		io.SetDoorOpenLamp(true)
+	} else {
+		elevator.Behaviour = types.Idle
+		io.SetDoorOpenLamp(false)
+	}
+}
+
+// resetEmergencyStop is called when the stop button is pressed during emergency stop.
+//   - Reads the position again, since the driver may have reconnected during the emergency stop
+//   - If the door is open, it closes after the normal door duration
+//   - If between floors without orders ahead, the elevator returns to the last floor
+func resetEmergencyStop(
+	cfg Config,
+	io elevio.ElevatorIO,
+	elevator *types.ElevState,
+	doorTimer **time.Timer,
+	doorTimeoutCh chan<- bool,
+	stuckTimer **time.Timer,
+	stuckTimeoutCh chan<- bool,
+) {
+	io.SetStopLamp(false)
+	elevator.EmergencyStop = false
+	if floor := io.GetFloor(); floor != -1 {
+		elevator.Floor = floor
+		elevator.BetweenFloors = false
+		io.SetFloorIndicator(floor)
+	} else if elevator.Behaviour == types.DoorOpen {
+		elevator.BetweenFloors = true
+		elevator.Behaviour = types.Idle
+		io.SetDoorOpenLamp(false)
+	} else {
+		elevator.BetweenFloors = true
+	}
+
+	if elevator.Behaviour == types.DoorOpen {
+		openDoor(cfg, io, elevator, doorTimer, doorTimeoutCh)
+		return
+	}
+	if !elevator.BetweenFloors || ChooseDirection(elevator, cfg.NodeID).Behaviour == types.Moving {
+		chooseAction(cfg, io, elevator, *doorTimer, doorTimeoutCh, stuckTimer, stuckTimeoutCh)
+		return
+	}
+
+	// The door can not open between floors, so move back towards the last floor
+	elevator.Dir = -elevator.Dir
+	if elevator.Dir == types.MD_Stop {
+		elevator.Dir = types.MD_Down
+	}
+	elevator.Behaviour = types.Moving
+	io.SetMotorDirection(elevator.Dir)
+	resetTimer(stuckTimer, stuckTimeoutCh, cfg.StuckTimeout)
+}
+
 // giveHallOrders is called on obstruction and stuck timeout.
 //   - Sends active hall orders to dispatcher and removes them from this elevator
-func giveHallOrders(elevator *types.ElevState, hallOrderCh chan<- types.HallOrder, elevUpdateCh chan<- types.ElevState) {
+func giveHallOrders(cfg Config, elevator *types.ElevState, hallOrderCh chan<- types.HallOrder, elevUpdateCh chan<- types.ElevState) {
 	utils.ForEachOrder(elevator.Orders, func(node, floor, btn int) {
-		if node == config.NodeID &&
+		if node == cfg.NodeID &&
 			types.ButtonType(btn) != types.BT_Cab &&
 			elevator.Orders[node][floor][btn] {
 			elevator.Orders[node][floor][btn] = false
-			elevUpdateCh <- *elevator
+			elevUpdateCh <- elevator.Clone()
 			hallOrderCh <- types.HallOrder{
 				Floor:  floor,
 				Button: types.HallType(btn),
@@ -202,38 +410,57 @@ func giveHallOrders(elevator *types.ElevState, hallOrderCh chan<- types.HallOrde
 }
 
 // syncLights is called on order updates from dispatcher.
-//   - Syncs lights based on received orders and button type
-func syncLights(elevator *types.ElevState, receivedOrders types.Orders) {
-	utils.ForEachOrder(elevator.Orders, func(node, floor, btn int) {
-		receivedOrder := receivedOrders[node][floor][btn]
-		if elevator.Orders[node][floor][btn] != receivedOrder {
-			// Sync hall lights
-			if btn != int(types.BT_Cab) {
-				elevio.SetButtonLamp(types.ButtonType(btn), floor, receivedOrders[node][floor][btn])
-			}
-			// Sync cab lights for own orders
-			if btn == int(types.BT_Cab) && node == config.NodeID {
-				elevio.SetButtonLamp(types.BT_Cab, floor, receivedOrders[node][floor][btn])
+//   - Syncs lights that change between the current and received orders
+func syncLights(cfg Config, io elevio.ElevatorIO, elevator *types.ElevState, receivedOrders types.Orders) {
+	for floor := range cfg.NumFloors {
+		for btn := range config.NumButtons {
+			if lit := isLit(receivedOrders, cfg.NodeID, floor, btn); lit != isLit(elevator.Orders, cfg.NodeID, floor, btn) {
+				io.SetButtonLamp(types.ButtonType(btn), floor, lit)
 			}
 		}
-	})
+	}
+}
+
+// restoreLights is called when the driver reconnects.
+//   - Sets every lamp from the current orders, since the elevator server may have been restarted
+func restoreLights(cfg Config, io elevio.ElevatorIO, elevator *types.ElevState) {
+	for floor := range cfg.NumFloors {
+		for btn := range config.NumButtons {
+			io.SetButtonLamp(types.ButtonType(btn), floor, isLit(elevator.Orders, cfg.NodeID, floor, btn))
+		}
+	}
+}
+
+// isLit returns true if a hall order is assigned to any node, or if nodeID has a cab order
+func isLit(orders types.Orders, nodeID, floor, btn int) bool {
+	if types.ButtonType(btn) == types.BT_Cab {
+		return orders[nodeID][floor][btn]
+	}
+	for node := range orders {
+		if orders[node][floor][btn] {
+			return true
+		}
+	}
+	return false
 }
 
 // openDoor modifies elevator state, sets door lamp and starts the door timer
 //   - Uses a hardware check to avoid opening door between floors
 func openDoor(
+	cfg Config,
+	io elevio.ElevatorIO,
 	elevator *types.ElevState,
 	doorTimer **time.Timer,
 	doorTimeoutCh chan<- bool,
 ) {
-	if elevio.GetFloor() == -1 {
+	if io.GetFloor() == -1 {
 		return
 	}
 
 	elevator.Behaviour = types.DoorOpen
-	elevio.SetDoorOpenLamp(true)
+	io.SetDoorOpenLamp(true)
 	if !elevator.Obstructed {
-		resetTimer(doorTimer, doorTimeoutCh, config.DoorOpenDuration)
+		resetTimer(doorTimer, doorTimeoutCh, cfg.DoorOpenDuration)
 	}
 }
 
@@ -0,0 +1,215 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"multivator/src/types"
+)
+
+// fakeIO is an elevator without hardware. The test moves it between floors and presses its buttons
+type fakeIO struct {
+	mtx      sync.Mutex
+	floor    int
+	motor    types.MotorDirection
+	door     bool
+	pressed  map[types.ButtonEvent]bool
+	lamps    map[types.ButtonEvent]bool
+	stopLamp bool
+}
+
+func newFakeIO(floor int) *fakeIO {
+	return &fakeIO{
+		floor:   floor,
+		pressed: make(map[types.ButtonEvent]bool),
+		lamps:   make(map[types.ButtonEvent]bool),
+	}
+}
+
+func (f *fakeIO) SetMotorDirection(dir types.MotorDirection) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.motor = dir
+}
+
+func (f *fakeIO) SetButtonLamp(button types.ButtonType, floor int, value bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.lamps[types.ButtonEvent{Floor: floor, Button: button}] = value
+}
+
+func (f *fakeIO) SetFloorIndicator(floor int) {}
+
+func (f *fakeIO) SetDoorOpenLamp(value bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.door = value
+}
+
+func (f *fakeIO) SetStopLamp(value bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.stopLamp = value
+}
+
+func (f *fakeIO) GetButton(button types.ButtonType, floor int) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.pressed[types.ButtonEvent{Floor: floor, Button: button}]
+}
+
+func (f *fakeIO) GetFloor() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.floor
+}
+
+func (f *fakeIO) GetStop() bool        { return false }
+func (f *fakeIO) GetObstruction() bool { return false }
+func (f *fakeIO) Connected() bool      { return true }
+
+func (f *fakeIO) press(floor int, button types.ButtonType) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.pressed[types.ButtonEvent{Floor: floor, Button: button}] = true
+}
+
+func (f *fakeIO) arrive(floor int) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.floor = floor
+}
+
+func (f *fakeIO) state() (types.MotorDirection, bool, map[types.ButtonEvent]bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	lamps := make(map[types.ButtonEvent]bool)
+	for button, lit := range f.lamps {
+		lamps[button] = lit
+	}
+	return f.motor, f.door, lamps
+}
+
+func testConfig(nodeID int) Config {
+	return Config{
+		NodeID:           nodeID,
+		NumElevators:     2,
+		NumFloors:        4,
+		PollRate:         time.Millisecond,
+		DoorOpenDuration: 10 * time.Millisecond,
+		StuckTimeout:     time.Hour,
+	}
+}
+
+// startExecutor runs an executor, and returns its elevator updates. Messages to the dispatcher are discarded
+func startExecutor(cfg Config, io *fakeIO) <-chan types.ElevState {
+	elevUpdateCh := make(chan types.ElevState, 100)
+	hallOrderCh := make(chan types.HallOrder)
+	sendSyncCh := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-hallOrderCh:
+			case <-sendSyncCh:
+			}
+		}
+	}()
+	go Run(cfg, io, elevUpdateCh, make(chan types.Orders), hallOrderCh, sendSyncCh, make(chan bool))
+	return elevUpdateCh
+}
+
+// eventually fails the test if done does not become true within a short time
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !done(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// waitFor returns the first elevator update that satisfies done
+func waitFor(t *testing.T, elevUpdateCh <-chan types.ElevState, what string, done func(types.ElevState) bool) types.ElevState {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case elevator := <-elevUpdateCh:
+			if done(elevator) {
+				return elevator
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestCabOrderIsServed(t *testing.T) {
+	cfg := testConfig(1)
+	io := newFakeIO(0)
+	elevUpdateCh := startExecutor(cfg, io)
+	cabLamp := types.ButtonEvent{Floor: 2, Button: types.BT_Cab}
+
+	// The door opens at the start floor, and closes before the elevator moves
+	io.press(2, types.BT_Cab)
+	waitFor(t, elevUpdateCh, "the cab order", func(elevator types.ElevState) bool {
+		return elevator.Orders[cfg.NodeID][2][types.BT_Cab]
+	})
+	eventually(t, "the elevator to move up with the cab lamp lit", func() bool {
+		motor, door, lamps := io.state()
+		return motor == types.MD_Up && !door && lamps[cabLamp]
+	})
+
+	io.arrive(2)
+	elevator := waitFor(t, elevUpdateCh, "the door to open", func(elevator types.ElevState) bool {
+		return elevator.Behaviour == types.DoorOpen
+	})
+	if elevator.Floor != 2 || elevator.Orders[cfg.NodeID][2][types.BT_Cab] {
+		t.Errorf("door opened at floor %d, want the cab order cleared at floor 2", elevator.Floor)
+	}
+	if motor, _, lamps := io.state(); motor != types.MD_Stop || lamps[cabLamp] {
+		t.Errorf("motor %v with cab lamp %v, want stopped with the lamp off", motor, lamps[cabLamp])
+	}
+}
+
+func TestExecutorsInOneProcess(t *testing.T) {
+	dir := t.TempDir()
+	ios := []*fakeIO{newFakeIO(0), newFakeIO(3)}
+	updates := make([]<-chan types.ElevState, len(ios))
+	for nodeID, io := range ios {
+		cfg := testConfig(nodeID)
+		cfg.StateDir = dir
+		updates[nodeID] = startExecutor(cfg, io)
+	}
+
+	ios[0].press(3, types.BT_Cab)
+	ios[1].press(0, types.BT_Cab)
+	for nodeID, floor := range []int{3, 0} {
+		elevator := waitFor(t, updates[nodeID], "the cab order", func(elevator types.ElevState) bool {
+			return elevator.Orders[nodeID][floor][types.BT_Cab]
+		})
+		if other := 1 - nodeID; elevator.Orders[other][floor][types.BT_Cab] {
+			t.Errorf("node %d set its cab order in the row of node %d", nodeID, other)
+		}
+	}
+
+	// Each executor saves its cab orders to a file of its own
+	ios[0].press(1, types.BT_Cab)
+	ios[1].press(2, types.BT_Cab)
+	for nodeID, floors := range [][]int{{3, 1}, {0, 2}} {
+		path := filepath.Join(dir, fmt.Sprintf("node-%d.json", nodeID))
+		eventually(t, fmt.Sprintf("node %d to save both cab orders", nodeID), func() bool {
+			data, err := os.ReadFile(path)
+			var saved savedCabOrders
+			if err != nil || json.Unmarshal(data, &saved) != nil {
+				return false
+			}
+			return saved.NodeID == nodeID && saved.CabOrders[floors[0]] && saved.CabOrders[floors[1]]
+		})
+	}
+}
@@ -8,37 +8,38 @@ import (
 
 // ChooseDirection is called in chooseAction and in cost function.
 //   - The algorithm prioritizes hall orders in the same direction as the elevator.
-func ChooseDirection(elevator *types.ElevState) types.DirnBehaviourPair {
+//   - Only the orders in the row of nodeID are considered.
+func ChooseDirection(elevator *types.ElevState, nodeID int) types.DirnBehaviourPair {
 	switch elevator.Dir {
 	case types.MD_Up:
 		switch {
-		case ordersAbove(elevator):
+		case ordersAbove(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Up, Behaviour: types.Moving}
-		case ordersHere(elevator):
+		case ordersHere(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Down, Behaviour: types.DoorOpen}
-		case ordersBelow(elevator):
+		case ordersBelow(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Down, Behaviour: types.Moving}
 		default:
 			return types.DirnBehaviourPair{Dir: types.MD_Stop, Behaviour: types.Idle}
 		}
 	case types.MD_Down:
 		switch {
-		case ordersBelow(elevator):
+		case ordersBelow(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Down, Behaviour: types.Moving}
-		case ordersHere(elevator):
+		case ordersHere(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Up, Behaviour: types.DoorOpen}
-		case ordersAbove(elevator):
+		case ordersAbove(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Up, Behaviour: types.Moving}
 		default:
 			return types.DirnBehaviourPair{Dir: types.MD_Stop, Behaviour: types.Idle}
 		}
 	case types.MD_Stop:
 		switch {
-		case ordersHere(elevator):
+		case ordersHere(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Stop, Behaviour: types.DoorOpen}
-		case ordersAbove(elevator):
+		case ordersAbove(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Up, Behaviour: types.Moving}
-		case ordersBelow(elevator):
+		case ordersBelow(elevator, nodeID):
 			return types.DirnBehaviourPair{Dir: types.MD_Down, Behaviour: types.Moving}
 		default:
 			return types.DirnBehaviourPair{Dir: types.MD_Stop, Behaviour: types.Idle}
@@ -50,21 +51,21 @@ func ChooseDirection(elevator *types.ElevState) types.DirnBehaviourPair {
 
 // OrdersToClearHere is called in clearAtCurrentFloor and in cost function.
 //   - Returns a list of orders to clear at the current floor.
-func OrdersToClearHere(elevator *types.ElevState) [config.NumButtons]bool {
+func OrdersToClearHere(elevator *types.ElevState, nodeID int) [config.NumButtons]bool {
 	var shouldClear [config.NumButtons]bool
 	shouldClear[types.BT_Cab] = true
 
 	switch elevator.Dir {
 	case types.MD_Up:
-		if !ordersAbove(elevator) &&
-			!elevator.Orders[config.NodeID][elevator.Floor][types.BT_HallUp] {
+		if !ordersAbove(elevator, nodeID) &&
+			!elevator.Orders[nodeID][elevator.Floor][types.BT_HallUp] {
 			shouldClear[types.BT_HallDown] = true
 		}
 		shouldClear[types.BT_HallUp] = true
 
 	case types.MD_Down:
-		if !ordersBelow(elevator) &&
-			!elevator.Orders[config.NodeID][elevator.Floor][types.BT_HallDown] {
+		if !ordersBelow(elevator, nodeID) &&
+			!elevator.Orders[nodeID][elevator.Floor][types.BT_HallDown] {
 			shouldClear[types.BT_HallUp] = true
 		}
 		shouldClear[types.BT_HallDown] = true
@@ -79,16 +80,16 @@ func OrdersToClearHere(elevator *types.ElevState) [config.NumButtons]bool {
 
 // ShouldStopHere is called on floor sensor updates, and in cost function.
 //   - Returns true if there are hall orders in the same direction or cab orders at the current floor.
-func ShouldStopHere(elevator *types.ElevState) bool {
+func ShouldStopHere(elevator *types.ElevState, nodeID int) bool {
 	switch elevator.Dir {
 	case types.MD_Up:
-		return elevator.Orders[config.NodeID][elevator.Floor][types.BT_HallUp] ||
-			elevator.Orders[config.NodeID][elevator.Floor][types.BT_Cab] ||
-			!ordersAbove(elevator)
+		return elevator.Orders[nodeID][elevator.Floor][types.BT_HallUp] ||
+			elevator.Orders[nodeID][elevator.Floor][types.BT_Cab] ||
+			!ordersAbove(elevator, nodeID)
 	case types.MD_Down:
-		return elevator.Orders[config.NodeID][elevator.Floor][types.BT_HallDown] ||
-			elevator.Orders[config.NodeID][elevator.Floor][types.BT_Cab] ||
-			!ordersBelow(elevator)
+		return elevator.Orders[nodeID][elevator.Floor][types.BT_HallDown] ||
+			elevator.Orders[nodeID][elevator.Floor][types.BT_Cab] ||
+			!ordersBelow(elevator, nodeID)
 	default:
 		return true
 	}
@@ -96,22 +97,22 @@ func ShouldStopHere(elevator *types.ElevState) bool {
 
 // clearAtCurrentFloor is called in chooseAction and at floor arrival.
 //   - Clears orders and lights in the same direction as the elevator.
-func clearAtCurrentFloor(elevator *types.ElevState) {
-	elevator.Orders[config.NodeID][elevator.Floor][types.BT_Cab] = false
-	elevio.SetButtonLamp(types.BT_Cab, elevator.Floor, false)
-	shouldClear := OrdersToClearHere(elevator)
+func clearAtCurrentFloor(io elevio.ElevatorIO, elevator *types.ElevState, nodeID int) {
+	elevator.Orders[nodeID][elevator.Floor][types.BT_Cab] = false
+	io.SetButtonLamp(types.BT_Cab, elevator.Floor, false)
+	shouldClear := OrdersToClearHere(elevator, nodeID)
 	for btn := range config.NumButtons {
 		if shouldClear[btn] {
-			elevator.Orders[config.NodeID][elevator.Floor][btn] = false
-			elevio.SetButtonLamp(types.ButtonType(btn), elevator.Floor, false)
+			elevator.Orders[nodeID][elevator.Floor][btn] = false
+			io.SetButtonLamp(types.ButtonType(btn), elevator.Floor, false)
 		}
 	}
 }
 
-func hasOrders(elevator *types.ElevState, startFloor int, endFloor int) bool {
+func hasOrders(elevator *types.ElevState, nodeID int, startFloor int, endFloor int) bool {
 	for floor := startFloor; floor < endFloor; floor++ {
 		for btn := range config.NumButtons {
-			if elevator.Orders[config.NodeID][floor][btn] {
+			if elevator.Orders[nodeID][floor][btn] {
 				return true
 			}
 		}
@@ -119,14 +120,14 @@ func hasOrders(elevator *types.ElevState, startFloor int, endFloor int) bool {
 	return false
 }
 
-func ordersAbove(elevator *types.ElevState) bool {
-	return hasOrders(elevator, elevator.Floor+1, config.NumFloors)
+func ordersAbove(elevator *types.ElevState, nodeID int) bool {
+	return hasOrders(elevator, nodeID, elevator.Floor+1, len(elevator.Orders[nodeID]))
 }
 
-func ordersBelow(elevator *types.ElevState) bool {
-	return hasOrders(elevator, 0, elevator.Floor)
+func ordersBelow(elevator *types.ElevState, nodeID int) bool {
+	return hasOrders(elevator, nodeID, 0, elevator.Floor)
 }
 
-func ordersHere(elevator *types.ElevState) bool {
-	return hasOrders(elevator, elevator.Floor, elevator.Floor+1)
+func ordersHere(elevator *types.ElevState, nodeID int) bool {
+	return hasOrders(elevator, nodeID, elevator.Floor, elevator.Floor+1)
 }
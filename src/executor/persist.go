@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"multivator/src/types"
+)
+
+// Cab orders are saved to Config.StateDir on every change, so a node restarting while alone
+// can still serve them. Orders restored through the network are merged with SyncCab as usual.
+
+type savedCabOrders struct {
+	NodeID    int
+	CabOrders []bool
+}
+
+// cabStore remembers the last saved cab orders, so the file is only written on changes
+type cabStore struct {
+	path      string
+	nodeID    int
+	numFloors int
+	saved     []bool
+}
+
+func newCabStore(cfg Config) *cabStore {
+	store := &cabStore{nodeID: cfg.NodeID, numFloors: cfg.NumFloors}
+	if cfg.StateDir != "" {
+		store.path = filepath.Join(cfg.StateDir, fmt.Sprintf("node-%d.json", cfg.NodeID))
+	}
+	return store
+}
+
+// load is called on startup.
+//   - Returns no orders if persistence is disabled or nothing has been saved yet
+//   - Ignores files from another node or building size
+func (store *cabStore) load() ([]bool, error) {
+	if store.path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved savedCabOrders
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", store.path, err)
+	}
+	if saved.NodeID != store.nodeID || len(saved.CabOrders) != store.numFloors {
+		return nil, fmt.Errorf("%s: saved for node %d with %d floors, ignoring it",
+			store.path, saved.NodeID, len(saved.CabOrders))
+	}
+	store.saved = saved.CabOrders
+	return slices.Clone(saved.CabOrders), nil
+}
+
+// save is called before handling each event in the executor.
+//   - Writes a temporary file and renames it over the old one, so a crash never leaves a partial file
+func (store *cabStore) save(orders types.Orders) error {
+	if store.path == "" {
+		return nil
+	}
+	cabOrders := make([]bool, store.numFloors)
+	for floor := range store.numFloors {
+		cabOrders[floor] = orders[store.nodeID][floor][types.BT_Cab]
+	}
+	if slices.Equal(cabOrders, store.saved) {
+		return nil
+	}
+
+	data, err := json.Marshal(savedCabOrders{NodeID: store.nodeID, CabOrders: cabOrders})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(store.path))
+
+	store.saved = cabOrders
+	return nil
+}
+
+// syncDir flushes the rename to disk. Not supported on every platform, so errors are ignored
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"os"
 
+	"multivator/lib/driver/elevio"
 	"multivator/src/config"
 	"multivator/src/dispatcher"
 	"multivator/src/executor"
@@ -11,16 +15,74 @@ import (
 
 func main() {
 	nodeID := flag.Int("id", 0, "Node ID of the elevator")
+	autoID := flag.Bool("auto-id", false, "Claim the lowest node ID that no running node uses, instead of --id")
+	configPath := flag.String("config", "", "Path to a JSON config file. Flags override values in the file")
+	config.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 	config.NodeID = *nodeID
+	if err := config.Load(flag.CommandLine, *configPath); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	costFn, _ := dispatcher.NewCostFunction(config.CostFunction) // Validated by config.Load
+
+	if *autoID && config.Transport == "unicast" {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, "--auto-id is not available with the unicast transport, since unicastHosts are indexed by id")
+		os.Exit(2)
+	}
+
+	tr, err := dispatcher.NewTransport(config.Transport)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Two nodes with the same id would overwrite each other's orders, so we refuse to join instead
+	if err := dispatcher.ClaimNodeID(tr, *autoID); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not join the cluster:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Joined as node", config.NodeID)
+
+	// The elevator server port is offset by node ID, so several nodes can run on one machine
+	elevatorAddr := fmt.Sprintf("localhost:%d", config.PeersPort+config.NodeID)
+	elevatorIO, err := elevio.Dial(elevatorAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not connect to elevator server:", err)
+		os.Exit(1)
+	}
 
 	elevUpdateCh := make(chan types.ElevState)
 	hallOrderCh := make(chan types.HallOrder)
 	sendSyncCh := make(chan bool)
 	orderUpdateCh := make(chan types.Orders, config.NumElevators)
 	openDoorCh := make(chan bool)
+	joinCh := make(chan bool)
+
+	go dispatcher.Run(costFn, tr, elevUpdateCh, orderUpdateCh, hallOrderCh, sendSyncCh, openDoorCh, joinCh)
+	go executor.Run(executor.NewConfig(), elevatorIO, elevUpdateCh, orderUpdateCh, hallOrderCh, sendSyncCh, openDoorCh)
+	readCommands(joinCh)
+}
 
-	go dispatcher.Run(elevUpdateCh, orderUpdateCh, hallOrderCh, sendSyncCh, openDoorCh)
-	go executor.Run(elevUpdateCh, orderUpdateCh, hallOrderCh, sendSyncCh, openDoorCh)
-	select {}
+// readCommands reads operator commands from stdin, one per line.
+//   - leave: hand off hall orders to the peers, then leave the cluster, such as for maintenance
+//   - join: rejoin the cluster, and restore cab orders from the peers
+func readCommands(joinCh chan<- bool) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		switch command := scanner.Text(); command {
+		case "leave":
+			joinCh <- false
+		case "join":
+			joinCh <- true
+		case "":
+		default:
+			fmt.Printf("Unknown command %q, must be leave or join\n", command)
+		}
+	}
+	select {} // Stdin is closed, such as when running in the background
 }
@@ -1,6 +1,10 @@
 package types
 
-import "multivator/src/config"
+import (
+	"fmt"
+
+	"multivator/src/config"
+)
 
 type ElevState struct {
 	Floor         int
@@ -10,9 +14,49 @@ type ElevState struct {
 	Obstructed    bool
 	IsStuck       bool
 	BetweenFloors bool
+	DriverLost    bool // Connection to the elevator server is lost
+	EmergencyStop bool // Toggled by the stop button
+}
+
+// Clone returns a copy of the elevator state that does not share orders with the original
+func (elevator ElevState) Clone() ElevState {
+	elevator.Orders = elevator.Orders.Clone()
+	return elevator
+}
+
+// Orders is indexed by [node][floor][button], and is sized on startup with NewOrders
+type Orders [][][config.NumButtons]bool
+
+// NewOrders allocates an empty order matrix for the given building size
+func NewOrders(numElevators, numFloors int) Orders {
+	orders := make(Orders, numElevators)
+	for node := range orders {
+		orders[node] = make([][config.NumButtons]bool, numFloors)
+	}
+	return orders
+}
+
+// Clone returns a deep copy, so the orders can be sent to another goroutine
+func (orders Orders) Clone() Orders {
+	clone := make(Orders, len(orders))
+	for node := range orders {
+		clone[node] = append([][config.NumButtons]bool(nil), orders[node]...)
+	}
+	return clone
 }
 
-type Orders [config.NumElevators][config.NumFloors][config.NumButtons]bool
+// HasShape returns true if the orders match the given building size
+func (orders Orders) HasShape(numElevators, numFloors int) bool {
+	if len(orders) != numElevators {
+		return false
+	}
+	for node := range orders {
+		if len(orders[node]) != numFloors {
+			return false
+		}
+	}
+	return true
+}
 
 type MotorDirection int
 
@@ -55,6 +99,18 @@ const (
 	DoorOpen
 )
 
+func (behaviour ElevBehaviour) String() string {
+	switch behaviour {
+	case Idle:
+		return "idle"
+	case Moving:
+		return "moving"
+	case DoorOpen:
+		return "doorOpen"
+	}
+	return fmt.Sprintf("ElevBehaviour(%d)", int(behaviour))
+}
+
 type DirnBehaviourPair struct {
 	Dir       MotorDirection
 	Behaviour ElevBehaviour
@@ -23,8 +23,7 @@ func ForEachOrder(orders types.Orders, action func(node, floor, btn int)) {
 // PrintStatus is called when a PeerUpdate is received
 func PrintStatus(peerUpdate peers.PeerUpdate) {
 	fmt.Printf("\rNode ID: %d | ", config.NodeID)
-	ownID := fmt.Sprintf("node-%d", config.NodeID)
-	if slices.Contains(peerUpdate.Peers, ownID) {
+	if slices.Contains(peerUpdate.Peers, peers.NodeID(config.NodeID)) {
 		fmt.Print("Status: Connected    \r")
 	} else {
 		fmt.Print("Status: Disconnected\r")